@@ -2,7 +2,6 @@ package main
 
 import (
 	"compress/gzip"
-	"fmt"
 	"io"
 	"os"
 	"path"
@@ -29,6 +28,20 @@ func (w *AlphaWorld) OpenChunk(x, z int) (io.ReadCloser, os.Error) {
 	return &ReadCloserPair{decompressor, file}, nil
 }
 
+// alphaTimestamps uses the modification times of alpha chunk files, which
+// have no timestamps of their own.
+type alphaTimestamps struct {
+	worldDir string
+}
+
+func (a *alphaTimestamps) ChunkTimestamp(x, z int) (ChunkTimestamp, os.Error) {
+	var fi, err = os.Stat(chunkPath(a.worldDir, x, z))
+	if err != nil {
+		return 0, err
+	}
+	return ChunkTimestamp(fi.Mtime_ns / 1e9), nil
+}
+
 type AlphaChunkPool struct {
 	chunkMap map[string]bool
 	worldDir string
@@ -45,29 +58,46 @@ func (p *AlphaChunkPool) Remaining() int {
 	return len(p.chunkMap)
 }
 
-func (w *AlphaWorld) ChunkPool() (ChunkPool, os.Error) {
+// ChunkPool lists the chunk files under the world directory. Files and
+// directories that can't be read are added to report; with a strict report
+// the first of them fails the pool once the walk is over.
+func (w *AlphaWorld) ChunkPool(report *CorruptionReport) (ChunkPool, os.Error) {
 	var errors = make(chan os.Error, 5)
-	var done = make(chan bool)
+	var done = make(chan os.Error)
 	go func() {
+		var firstErr os.Error
 		for error := range errors {
-			fmt.Fprintln(os.Stderr, error) // TODO: return errors
+			var region = ""
+			if pathErr, isPathErr := error.(*os.PathError); isPathErr {
+				region = pathErr.Path
+			}
+			var reportErr = report.Add(region, "", error)
+			if firstErr == nil {
+				firstErr = reportErr
+			}
 		}
-		done <- true
+		done <- firstErr
 	}()
-	var v = &visitor{make(map[string]bool), w.mask}
+	var v = &visitor{make(map[string]bool), w.mask, path.Clean(w.worldDir)}
 	filepath.Walk(w.worldDir, v, errors)
 	close(errors)
-	<-done
+	var walkErr = <-done
+	if walkErr != nil {
+		return nil, walkErr
+	}
 	return &AlphaChunkPool{v.chunks, w.worldDir}, nil
 }
 
 type visitor struct {
 	chunks map[string]bool
 	mask   ChunkMask
+	root   string
 }
 
+// VisitDir keeps out of the DIM-1 and DIM1 folders of other dimensions that
+// sit among the overworld's chunk folders.
 func (v *visitor) VisitDir(dir string, f *os.FileInfo) bool {
-	return true
+	return path.Clean(dir) == v.root || !strings.HasPrefix(f.Name, "DIM")
 }
 
 func (v *visitor) VisitFile(file string, f *os.FileInfo) {
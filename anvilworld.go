@@ -0,0 +1,23 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+)
+
+// AnvilWorld reads the region/r.X.Z.mca files written since Minecraft 1.2.
+// The region layout is the same as BetaWorld's, only the chunks inside are
+// split into Level.Sections.
+type AnvilWorld struct {
+	worldDir string
+	mask     ChunkMask
+}
+
+func (w *AnvilWorld) OpenChunk(x, z int) (io.ReadCloser, os.Error) {
+	return openRegionChunk(path.Join(w.worldDir, "region"), "mca", x, z)
+}
+
+func (w *AnvilWorld) ChunkPool(report *CorruptionReport) (ChunkPool, os.Error) {
+	return regionChunkPool(path.Join(w.worldDir, "region"), "mca", w.mask, report)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"compress/zlib"
 	"encoding/binary"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"path"
 	"strings"
 	"strconv"
+	"time"
 )
 
 var (
@@ -20,75 +22,123 @@ type BetaWorld struct {
 	mask     ChunkMask
 }
 
-type McrFile struct {
-	*os.File
+func (w *BetaWorld) OpenChunk(x, z int) (io.ReadCloser, os.Error) {
+	return openRegionChunk(path.Join(w.worldDir, "region"), "mcr", x, z)
 }
 
-func (w *BetaWorld) OpenChunk(x, z int) (io.ReadCloser, os.Error) {
-	var mcrName = fmt.Sprintf("r.%v.%v.mcr", x>>5, z>>5)
-	var mcrPath = path.Join(w.worldDir, "region", mcrName)
+func (w *BetaWorld) ChunkPool(report *CorruptionReport) (ChunkPool, os.Error) {
+	return regionChunkPool(path.Join(w.worldDir, "region"), "mcr", w.mask, report)
+}
 
-	var file, mcrOpenErr = os.Open(mcrPath, os.O_RDONLY, 0666)
-	if mcrOpenErr != nil {
-		return nil, mcrOpenErr
-	}
-	defer func() {
-		if file != nil {
-			file.Close()
-		}
-	}()
+// openRegionChunk opens chunk x,z from the r.X.Z.<ext> region file it
+// belongs to. McRegion (.mcr) and Anvil (.mca) files share this layout.
+func openRegionChunk(regionDirname, ext string, x, z int) (io.ReadCloser, os.Error) {
+	var mcrName = fmt.Sprintf("r.%v.%v.%v", x>>5, z>>5, ext)
+	return openRegionFileChunk(path.Join(regionDirname, mcrName), x, z)
+}
 
-	var mcr = &McrFile{file}
-	var loc, readLocErr = mcr.ReadLocation(x, z)
-	if readLocErr != nil {
-		return nil, readLocErr
-	}
+// Compression types of region file chunks. Chunks too big for the region
+// file are flagged with externalChunkFlag and kept in c.X.Z.mcc next to it.
+const (
+	compressionGzip   = 1
+	compressionZlib   = 2
+	compressionNone   = 3
+	compressionLZ4    = 4
+	compressionCustom = 127
+	externalChunkFlag = 0x80
+)
 
-	if loc == 0 {
-		return nil, os.NewError(fmt.Sprintf("Chunk missing: %v,%v in %v. %v", x, z, mcrName, (x&31)+(z&31)*32))
+var (
+	ChunkLengthError     = os.NewError("Chunk length runs past its sectors")
+	CompressionTypeError = os.NewError("Unknown compression type")
+)
+
+// A RegionError is returned for a chunk that can't be read out of its region
+// file.
+type RegionError struct {
+	Region string
+	X, Z   int
+	Err    os.Error
+}
+
+func (e *RegionError) String() string {
+	return fmt.Sprintf("%v: chunk %v,%v: %v", e.Region, e.X, e.Z, e.Err)
+}
+
+// openRegionFileChunk opens chunk x,z of a region file. Only the low five
+// bits of x and z matter.
+func openRegionFileChunk(mcrPath string, x, z int) (io.ReadCloser, os.Error) {
+	var r, err = regionCache.OpenChunk(mcrPath, x, z)
+	if err != nil {
+		var _, isRegionErr = err.(*RegionError)
+		if !isRegionErr {
+			err = &RegionError{mcrPath, x, z, err}
+		}
+		return nil, err
 	}
+	return r, nil
+}
 
+// openExternalChunk opens the c.X.Z.mcc file holding an oversized chunk.
+// Its name uses absolute chunk coordinates, which are worked out from the
+// region file's name.
+func openExternalChunk(mcrPath string, x, z int) (*os.File, os.Error) {
+	var fields = strings.Split(path.Base(mcrPath), ".", -1)
+	if len(fields) != 4 {
+		return nil, os.NewError("Can't place external chunk for " + path.Base(mcrPath))
+	}
 	var (
-		length          uint32
-		compressionType byte
+		rx, rxErr = strconv.Atoi(fields[1])
+		rz, rzErr = strconv.Atoi(fields[2])
 	)
-
-	var _, seekErr = mcr.Seek(int64(loc.Offset()), 0)
-	if seekErr != nil {
-		return nil, seekErr
+	if rxErr != nil || rzErr != nil {
+		return nil, os.NewError("Can't place external chunk for " + path.Base(mcrPath))
 	}
 
-	var lengthReadErr = binary.Read(mcr, binary.BigEndian, &length)
-	if lengthReadErr != nil {
-		return nil, lengthReadErr
-	}
+	var mccName = fmt.Sprintf("c.%v.%v.mcc", rx*32+(x&31), rz*32+(z&31))
+	return os.Open(path.Join(path.Dir(mcrPath), mccName), os.O_RDONLY, 0666)
+}
 
-	var compressionTypeErr = binary.Read(mcr, binary.BigEndian, &compressionType)
-	if compressionTypeErr != nil {
-		return nil, compressionTypeErr
+// decompressChunk wraps a chunk's stored bytes in the reader for its
+// compression type.
+func decompressChunk(r io.Reader, compressionType byte) (io.ReadCloser, os.Error) {
+	switch compressionType {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZlib:
+		return zlib.NewReader(r)
+	case compressionNone:
+		return nopCloser{r}, nil
+	case compressionLZ4:
+		return nopCloser{newLZ4BlockReader(r)}, nil
+	case compressionCustom:
+		var name, nameErr = readJavaString(r)
+		if nameErr != nil {
+			return nil, nameErr
+		}
+		return nil, os.NewError("Unsupported compression " + name)
 	}
+	return nil, CompressionTypeError
+}
 
-	var r, zlibNewErr = zlib.NewReader(mcr)
-	if zlibNewErr != nil {
-		return nil, zlibNewErr
+// readJavaString reads a string written by Java's DataOutput.writeUTF.
+func readJavaString(r io.Reader) (string, os.Error) {
+	var length uint16
+	var err = binary.Read(r, binary.BigEndian, &length)
+	if err != nil {
+		return "", err
 	}
+	var bytes = make([]byte, length)
+	_, err = io.ReadFull(r, bytes)
+	return string(bytes), err
+}
 
-	var pair = &ReadCloserPair{r, file}
-	file = nil
-	return pair, nil
+type nopCloser struct {
+	io.Reader
 }
 
-func (r McrFile) ReadLocation(x, z int) (ChunkLocation, os.Error) {
-	var _, seekErr = r.Seek(int64(4*((x&31)+(z&31)*32)), 0)
-	if seekErr != nil {
-		return ChunkLocation(0), seekErr
-	}
-	var location uint32
-	var readErr = binary.Read(r, binary.BigEndian, &location)
-	if readErr != nil {
-		return ChunkLocation(0), readErr
-	}
-	return ChunkLocation(location), nil
+func (nopCloser) Close() os.Error {
+	return nil
 }
 
 type ChunkLocation uint32
@@ -101,15 +151,96 @@ func (cl ChunkLocation) Sectors() int {
 	return (int(cl) & 0xff)
 }
 
-func (w *BetaWorld) ChunkPool() (ChunkPool, os.Error) {
-	var regionDirname = path.Join(w.worldDir, "region")
+// ChunkTimestamp is when a chunk was last saved, in seconds since the Unix
+// epoch, or 0 if the region file doesn't say.
+type ChunkTimestamp uint32
+
+func (ct ChunkTimestamp) Seconds() int64 {
+	return int64(ct)
+}
+
+func (ct ChunkTimestamp) Time() *time.Time {
+	return time.SecondsToUTC(int64(ct))
+}
+
+// regionTimestamps looks chunk timestamps up in r.X.Z.<ext> region files.
+type regionTimestamps struct {
+	regionDirname, ext string
+}
+
+func (r *regionTimestamps) ChunkTimestamp(x, z int) (ChunkTimestamp, os.Error) {
+	var mcrName = fmt.Sprintf("r.%v.%v.%v", x>>5, z>>5, r.ext)
+	var timestamps, err = regionCache.Timestamps(path.Join(r.regionDirname, mcrName))
+	if err != nil {
+		return 0, err
+	}
+	return timestamps[(x&31)+(z&31)*32], nil
+}
+
+// regionChunkPool lists the chunks in every region file. Unreadable region
+// files and chunks whose location falls outside their file are left out of
+// the pool and added to report.
+func regionChunkPool(regionDirname, ext string, mask ChunkMask, report *CorruptionReport) (ChunkPool, os.Error) {
+	var regions, listErr = listRegionFiles(regionDirname, ext)
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	var pool = &BetaChunkPool{make(map[uint64]bool)}
+
+	for _, region := range regions {
+		var locations, size, locationsErr = regionCache.Locations(region.path)
+		if locationsErr != nil {
+			var reportErr = report.Add(region.path, "", locationsErr)
+			if reportErr != nil {
+				return nil, reportErr
+			}
+			continue
+		}
+
+		for i, location := range locations {
+			if location != 0 {
+				var (
+					x = region.x*32 + (i & 31)
+					z = region.z*32 + (i >> 5)
+				)
+
+				if mask.IsMasked(x, z) {
+					continue
+				}
+
+				if location.Offset() < regionHeaderSize || location.Sectors() == 0 || int64(location.Offset()) >= size {
+					var reportErr = report.Add(region.path, fmt.Sprintf("%v,%v", x, z), ChunkLocationError)
+					if reportErr != nil {
+						return nil, &RegionError{region.path, x, z, reportErr}
+					}
+					continue
+				}
+
+				pool.chunkMap[betaChunkPoolKey(x, z)] = true
+			}
+		}
+	}
+
+	return pool, nil
+}
+
+// regionFileName is a region file found by listRegionFiles, with the region
+// coordinates from its name.
+type regionFileName struct {
+	path string
+	x, z int
+}
+
+// listRegionFiles finds the r.X.Z.<ext> files in regionDirname.
+func listRegionFiles(regionDirname, ext string) ([]regionFileName, os.Error) {
 	var dir, dirOpenErr = os.Open(regionDirname, os.O_RDONLY, 0666)
 	if dirOpenErr != nil {
 		return nil, dirOpenErr
 	}
 	defer dir.Close()
 
-	var pool = &BetaChunkPool{make(map[uint64]bool)}
+	var regions []regionFileName
 
 	for {
 		var filenames, readErr = dir.Readdirnames(1)
@@ -123,44 +254,19 @@ func (w *BetaWorld) ChunkPool() (ChunkPool, os.Error) {
 
 		var fields = strings.FieldsFunc(filenames[0], func(c int) bool { return c == '.' })
 
-		if len(fields) == 4 {
+		if len(fields) == 4 && fields[0] == "r" && fields[3] == ext {
 			var (
 				rx, rxErr = strconv.Atoi(fields[1])
 				rz, ryErr = strconv.Atoi(fields[2])
 			)
 
 			if rxErr == nil && ryErr == nil {
-				var regionFilename = path.Join(regionDirname, filenames[0])
-				var region, regionOpenErr = os.Open(regionFilename, os.O_RDONLY, 0666)
-				if regionOpenErr != nil {
-					return nil, regionOpenErr
-				}
-				defer region.Close()
-
-				for cz := 0; cz < 32; cz++ {
-					for cx := 0; cx < 32; cx++ {
-						var location uint32
-						var readErr = binary.Read(region, binary.BigEndian, &location)
-						if readErr != nil {
-							return nil, readErr
-						}
-						if location != 0 {
-							var (
-								x = rx*32 + cx
-								z = rz*32 + cz
-							)
-
-							if !w.mask.IsMasked(x, z) {
-								pool.chunkMap[betaChunkPoolKey(x, z)] = true
-							}
-						}
-					}
-				}
+				regions = append(regions, regionFileName{path.Join(regionDirname, filenames[0]), rx, rz})
 			}
 		}
 	}
 
-	return pool, nil
+	return regions, nil
 }
 
 type BetaChunkPool struct {
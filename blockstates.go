@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"nbt"
+	"os"
+	"strings"
+	"sync"
+)
+
+type blockStateCandidate struct {
+	properties map[string]string
+	blockId    uint16
+}
+
+// resolveBlockStates replaces the palette indexes of a 1.13+ chunk with the
+// block ids the "state" entries of blocks.json map them to.
+func resolveBlockStates(chunk *nbt.Chunk) {
+	var blockIds = make([]uint16, len(chunk.Palette))
+	for i, _ := range chunk.Palette {
+		blockIds[i] = blockStateId(&chunk.Palette[i])
+	}
+
+	for i, index := range chunk.Blocks {
+		chunk.Blocks[i] = blockIds[index]
+	}
+
+	chunk.Palette = nil
+}
+
+// blockStateId picks the candidate for the state's name whose properties
+// all match and which matches the most properties. Unknown names come out
+// as air, so that unmapped plants and the like don't turn into solid cubes.
+func blockStateId(state *nbt.BlockState) uint16 {
+	var (
+		best        = -1
+		bestBlockId = uint16(0)
+	)
+
+	for _, candidate := range blockStateMap[qualifyBlockName(state.Name)] {
+		var matches = true
+		for key, value := range candidate.properties {
+			if state.Properties[key] != value {
+				matches = false
+				break
+			}
+		}
+		if matches && len(candidate.properties) > best {
+			best = len(candidate.properties)
+			bestBlockId = candidate.blockId
+		}
+	}
+
+	if best == -1 {
+		var key = state.String()
+		unknownBlockStatesMutex.Lock()
+		if !unknownBlockStates[key] {
+			unknownBlockStates[key] = true
+			fmt.Fprintln(os.Stderr, "Unknown block state", key)
+		}
+		unknownBlockStatesMutex.Unlock()
+	}
+
+	return bestBlockId
+}
+
+// addBlockState registers a "minecraft:name[key=value,...]" state from
+// blocks.json.
+func addBlockState(state string, blockId uint16) {
+	var (
+		name       = state
+		properties map[string]string
+	)
+
+	var open = strings.Index(state, "[")
+	if open != -1 && strings.HasSuffix(state, "]") {
+		name = state[:open]
+		properties = make(map[string]string)
+		for _, pair := range strings.Split(state[open+1:len(state)-1], ",", -1) {
+			var kv = strings.Split(pair, "=", 2)
+			if len(kv) == 2 {
+				properties[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+
+	name = qualifyBlockName(name)
+	blockStateMap[name] = append(blockStateMap[name], blockStateCandidate{properties, blockId})
+}
+
+func qualifyBlockName(name string) string {
+	if strings.Index(name, ":") == -1 {
+		return "minecraft:" + name
+	}
+	return name
+}
+
+func init() {
+	blockStateMap = make(map[string][]blockStateCandidate)
+	unknownBlockStates = make(map[string]bool)
+}
+
+var (
+	blockStateMap      map[string][]blockStateCandidate
+	unknownBlockStates map[string]bool
+
+	// Chunks are decoded on several goroutines at once.
+	unknownBlockStatesMutex sync.Mutex
+)
@@ -1,5 +1,19 @@
 package main
 
+import (
+	"fmt"
+	"nbt"
+	"os"
+	"sync"
+)
+
+// Block ids above 255, which Anvil spreads over an Add nibble, keep that
+// nibble in the top four bits of a block, above the metadata. Nothing in
+// blocks.json describes them, so they are treated as air.
+const extendedIdMask = 0xf000
+
+var emptyBlockInfo = BlockInfoByte(4)
+
 type BoundaryLocator struct {
 	describer BlockDescriber
 }
@@ -11,10 +25,14 @@ func (b *BoundaryLocator) Init() {
 }
 
 func (b *BoundaryLocator) IsBoundary(blockId, otherBlockId uint16) bool {
-	var (
-		block = b.describer.BlockInfo(byte(blockId & 0xff))
+	if blockId&extendedIdMask != 0 {
+		return false
+	}
+	var block = b.describer.BlockInfo(byte(blockId & 0xff))
+	var other BlockInfo = emptyBlockInfo
+	if otherBlockId&extendedIdMask == 0 {
 		other = b.describer.BlockInfo(byte(otherBlockId & 0xff))
-	)
+	}
 
 	if !block.IsEmpty() {
 		if other.IsEmpty() {
@@ -125,3 +143,25 @@ func init() {
 var (
 	blockTypeMap map[byte]*BlockType
 )
+
+var (
+	unknownBlockIds      = make(map[int]bool)
+	unknownBlockIdsMutex sync.Mutex
+)
+
+// reportExtendedIds says once for each id above 255 in the chunk that it
+// will be left out.
+func reportExtendedIds(chunk *nbt.Chunk) {
+	for _, block := range chunk.Blocks {
+		if block&extendedIdMask == 0 {
+			continue
+		}
+		var blockId = int(block&0xff) | int(block>>12)<<8
+		unknownBlockIdsMutex.Lock()
+		if !unknownBlockIds[blockId] {
+			unknownBlockIds[blockId] = true
+			fmt.Fprintln(os.Stderr, "Unknown block id", blockId, "left out")
+		}
+		unknownBlockIdsMutex.Unlock()
+	}
+}
@@ -6,8 +6,8 @@ echo "const version = \""$(git describe || echo "unknown")"\"" >> version.go
 
 gofmt.exe -w *.go || exit
 
-8g nbt.go || exit
+8g -o nbt.8 nbt.go nbtpalette.go nbttags.go nbtwrite.go nbtunmarshal.go nbtstream.go nbtsnbt.go nbtformat.go nbtlimits.go || exit
 gopack grc nbt.a nbt.8 || exit
 
-8g -I. mcobj.go version.go obj.go mtl.go faces.go prt.go sides.go sideCache.go enclosedChunk.go world.go blocktypes.go alphaworld.go betaworld.go chunkmasks.go || exit
+8g -I. mcobj.go version.go obj.go mtl.go faces.go prt.go sides.go sideCache.go enclosedChunk.go world.go blocktypes.go alphaworld.go betaworld.go anvilworld.go chunkmasks.go blockstates.go nbtcommand.go lz4.go regioncache.go chunkloader.go chunkcache.go corruption.go checkcommand.go meshcache.go dimension.go leveldat.go maskexpr.go pngmask.go || exit
 8l -L. -o mcobj.exe mcobj.8
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"nbt"
+	"os"
+	"path"
+)
+
+// checkMain runs "mcobj check", which validates every region file of the
+// given worlds and exits with status 1 if any problems were found.
+func checkMain(args []string) {
+	os.Args = append([]string{os.Args[0] + " check"}, args...)
+
+	var (
+		jsonFilename string
+		decode       bool
+	)
+	flag.StringVar(&jsonFilename, "json", "", "Also write the problems to this JSON file")
+	flag.BoolVar(&decode, "decode", true, "Decompress and decode every chunk, not just the headers")
+	var dimName = flag.String("dim", "overworld", "Dimension to check: overworld, nether, end or a dimension folder in the save")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: mcobj check [options] world...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var (
+		report       = NewCorruptionReport(false)
+		regionCount  = 0
+		checkedCount = 0
+	)
+
+	var dim = LookupDimension(*dimName)
+	for i := 0; i < flag.NArg(); i++ {
+		var regionDirname = path.Join(dim.Path(flag.Arg(i)), "region")
+		var ext = "mcr"
+		if hasRegionFiles(regionDirname, "mca") {
+			ext = "mca"
+		}
+
+		var regions, listErr = listRegionFiles(regionDirname, ext)
+		if listErr != nil {
+			report.Add(regionDirname, "", listErr)
+			continue
+		}
+
+		for _, region := range regions {
+			checkedCount += checkRegion(region, decode, report)
+			regionCount++
+		}
+	}
+
+	report.PrintTable()
+	fmt.Printf("Checked %d chunks in %d region files: %d problems\n", checkedCount, regionCount, report.Len())
+
+	if jsonFilename != "" {
+		var writeErr = report.WriteJSON(jsonFilename)
+		if writeErr != nil {
+			fmt.Fprintln(os.Stderr, writeErr)
+			os.Exit(2)
+		}
+	}
+
+	if report.Len() != 0 {
+		os.Exit(1)
+	}
+}
+
+// checkRegion adds the problems with one region file to report, returning
+// the number of chunks it holds.
+func checkRegion(region regionFileName, decode bool, report *CorruptionReport) int {
+	var rf, openErr = openRegionFile(region.path)
+	if openErr != nil {
+		report.Add(region.path, "", openErr)
+		return 0
+	}
+	defer rf.file.Close()
+
+	// owners holds, for each sector, the slot of the chunk using it plus one.
+	var sectorCount = int((rf.size + 4095) / 4096)
+	var owners = make([]int, sectorCount)
+
+	var checked = 0
+	for i, loc := range rf.locations {
+		if loc == 0 {
+			continue
+		}
+		checked++
+
+		var (
+			x     = region.x*32 + (i & 31)
+			z     = region.z*32 + (i >> 5)
+			chunk = fmt.Sprintf("%v,%v", x, z)
+			first = loc.Offset() / 4096
+			count = loc.Sectors()
+		)
+
+		switch {
+		case first < regionHeaderSize/4096:
+			report.Add(region.path, chunk, os.NewError("Sectors overlap the region header"))
+			continue
+		case count == 0:
+			report.Add(region.path, chunk, os.NewError("Chunk has no sectors"))
+			continue
+		case first >= sectorCount:
+			report.Add(region.path, chunk, os.NewError("Offset beyond end of file"))
+			continue
+		case first+count > sectorCount:
+			report.Add(region.path, chunk, os.NewError("Sectors run past end of file"))
+		}
+
+		// Every sector is claimed, even after an overlap is reported, so
+		// that later chunks overlapping the rest are caught too.
+		var overlapped = false
+		for s := first; s < first+count && s < sectorCount; s++ {
+			if owners[s] != 0 && !overlapped {
+				var other = owners[s] - 1
+				report.Add(region.path, chunk, os.NewError(fmt.Sprintf("Sectors overlap chunk %v,%v", region.x*32+(other&31), region.z*32+(other>>5))))
+				overlapped = true
+			}
+			if owners[s] == 0 {
+				owners[s] = i + 1
+			}
+		}
+
+		var header [5]byte
+		var _, readErr = rf.file.ReadAt(header[:], int64(loc.Offset()))
+		if readErr != nil {
+			report.Add(region.path, chunk, readErr)
+			continue
+		}
+
+		var (
+			length          = binary.BigEndian.Uint32(header[0:4])
+			compressionType = header[4]
+		)
+		if compressionType&externalChunkFlag == 0 && (length == 0 || int64(length)+4 > int64(count)*4096) {
+			report.Add(region.path, chunk, os.NewError(fmt.Sprintf("Declared length %d doesn't fit in %d sectors", length, count)))
+			continue
+		}
+
+		switch compressionType &^ externalChunkFlag {
+		case compressionGzip, compressionZlib, compressionNone, compressionLZ4, compressionCustom:
+		default:
+			report.Add(region.path, chunk, os.NewError(fmt.Sprintf("Unknown compression type %d", compressionType)))
+			continue
+		}
+
+		if decode {
+			checkChunkData(region.path, x, z, report)
+		}
+	}
+
+	return checked
+}
+
+// checkChunkData decodes a chunk's NBT, positions only, and checks that the
+// chunk is where its slot says it is.
+func checkChunkData(regionPath string, x, z int, report *CorruptionReport) {
+	var chunk = fmt.Sprintf("%v,%v", x, z)
+
+	var r, openErr = regionCache.OpenChunk(regionPath, x, z)
+	if openErr != nil {
+		report.Add(regionPath, chunk, openErr)
+		return
+	}
+	defer r.Close()
+
+	var decoded, decodeErr = nbt.ReadChunk(r, 0)
+	if decodeErr != nil {
+		report.Add(regionPath, chunk, decodeErr)
+		return
+	}
+
+	if decoded.XPos != x || decoded.ZPos != z {
+		report.Add(regionPath, chunk, os.NewError(fmt.Sprintf("Chunk data says it is %v,%v", decoded.XPos, decoded.ZPos)))
+	}
+}
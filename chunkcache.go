@@ -0,0 +1,98 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"nbt"
+	"sync"
+)
+
+// A ChunkCache holds recently decoded chunks by position, so that a chunk
+// decoded for its side can be enclosed later without being decoded again.
+// It drops the least recently used chunk once it holds capacity of them.
+type ChunkCache struct {
+	mutex    sync.Mutex
+	capacity int
+	chunks   map[uint64]*list.Element
+	lru      *list.List
+
+	hits, misses int
+}
+
+type cachedChunk struct {
+	key   uint64
+	chunk *nbt.Chunk
+}
+
+func NewChunkCache(capacity int) *ChunkCache {
+	return &ChunkCache{capacity: capacity, chunks: make(map[uint64]*list.Element), lru: list.New()}
+}
+
+// Get returns the cached chunk x,z, counting a hit or a miss.
+func (c *ChunkCache) Get(x, z int) (*nbt.Chunk, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var element, cached = c.chunks[betaChunkPoolKey(x, z)]
+	if !cached {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedChunk).chunk, true
+}
+
+// countHit counts a chunk served by a load already under way.
+func (c *ChunkCache) countHit() {
+	c.mutex.Lock()
+	c.hits++
+	c.mutex.Unlock()
+}
+
+func (c *ChunkCache) Add(x, z int, chunk *nbt.Chunk) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var key = betaChunkPoolKey(x, z)
+	var element, cached = c.chunks[key]
+	if cached {
+		element.Value.(*cachedChunk).chunk = chunk
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	for c.lru.Len() >= c.capacity {
+		var oldest = c.lru.Remove(c.lru.Back()).(*cachedChunk)
+		c.chunks[oldest.key] = nil, false
+	}
+	c.chunks[key] = c.lru.PushFront(&cachedChunk{key, chunk})
+}
+
+// Remove drops chunk x,z once nothing will ask for it again.
+func (c *ChunkCache) Remove(x, z int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var key = betaChunkPoolKey(x, z)
+	var element, cached = c.chunks[key]
+	if cached {
+		c.lru.Remove(element)
+		c.chunks[key] = nil, false
+	}
+}
+
+func (c *ChunkCache) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var total = c.hits + c.misses
+	if total == 0 {
+		return "Chunk cache: unused"
+	}
+	return fmt.Sprintf("Chunk cache: %d hits, %d misses (%.1f%% hit rate)", c.hits, c.misses, 100*float64(c.hits)/float64(total))
+}
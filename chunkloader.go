@@ -0,0 +1,126 @@
+package main
+
+import (
+	"nbt"
+	"os"
+	"sync"
+)
+
+// A ChunkLoader reads, decompresses and decodes chunks on a pool of
+// goroutines. Chunks are asked for ahead of time with Prefetch and collected
+// with Load; every Prefetch must be matched by a Load. Every chunk is decoded
+// with the same fields, so that a chunk loaded for its side and later for
+// itself is decoded once: while both requests are outstanding they share
+// one load, and after that the chunk waits in the cache.
+type ChunkLoader struct {
+	opener   ChunkOpener
+	fields   nbt.ChunkFields
+	cache    *ChunkCache
+	requests chan *chunkLoad
+	mutex    sync.Mutex
+	loads    map[uint64]*chunkLoad
+}
+
+type chunkLoad struct {
+	x, z   int
+	wanted int       // Prefetches not yet collected by Load
+	done   chan bool // Closed once chunk and err are set
+	chunk  *nbt.Chunk
+	err    os.Error
+}
+
+func NewChunkLoader(opener ChunkOpener, fields nbt.ChunkFields, cache *ChunkCache, workers int) *ChunkLoader {
+	var loader = &ChunkLoader{
+		opener:   opener,
+		fields:   fields,
+		cache:    cache,
+		requests: make(chan *chunkLoad, chunkPrefetch*5),
+		loads:    make(map[uint64]*chunkLoad),
+	}
+	for i := 0; i < workers; i++ {
+		go loader.work()
+	}
+	return loader
+}
+
+func (l *ChunkLoader) work() {
+	for load := range l.requests {
+		load.chunk, load.err = loadChunk2(l.opener, load.x, load.z, l.fields)
+		close(load.done)
+	}
+}
+
+// Prefetch queues chunk x,z to be loaded, unless it is already queued or
+// cached.
+func (l *ChunkLoader) Prefetch(x, z int) {
+	var key = betaChunkPoolKey(x, z)
+
+	l.mutex.Lock()
+	var load, present = l.loads[key]
+	if present {
+		l.cache.countHit()
+	} else {
+		load = &chunkLoad{x: x, z: z, done: make(chan bool)}
+		l.loads[key] = load
+
+		var chunk, cached = l.cache.Get(x, z)
+		if cached {
+			load.chunk = chunk
+			close(load.done)
+			present = true
+		}
+	}
+	load.wanted++
+	l.mutex.Unlock()
+
+	if !present {
+		l.requests <- load
+	}
+}
+
+// Load returns chunk x,z, waiting for it if it was prefetched and loading it
+// on the spot if it wasn't. The chunk stays cached for later Loads until
+// Forget.
+func (l *ChunkLoader) Load(x, z int) (*nbt.Chunk, os.Error) {
+	var key = betaChunkPoolKey(x, z)
+
+	l.mutex.Lock()
+	var load, present = l.loads[key]
+	if present {
+		load.wanted--
+		if load.wanted == 0 {
+			l.loads[key] = nil, false
+		}
+	}
+	l.mutex.Unlock()
+
+	if !present {
+		var chunk, cached = l.cache.Get(x, z)
+		if cached {
+			return chunk, nil
+		}
+		var loadErr os.Error
+		chunk, loadErr = loadChunk2(l.opener, x, z, l.fields)
+		if loadErr == nil {
+			l.cache.Add(x, z, chunk)
+		}
+		return chunk, loadErr
+	}
+
+	<-load.done
+	if load.err == nil {
+		l.cache.Add(x, z, load.chunk)
+	}
+	return load.chunk, load.err
+}
+
+// Forget drops chunk x,z from the cache once it has been enclosed, as its
+// side is in the SideCache from then on.
+func (l *ChunkLoader) Forget(x, z int) {
+	l.cache.Remove(x, z)
+}
+
+// Close stops the workers once the queued loads are done.
+func (l *ChunkLoader) Close() {
+	close(l.requests)
+}
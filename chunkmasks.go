@@ -1,9 +1,24 @@
 package main
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type ChunkMask interface {
 	IsMasked(x, z int) bool
 }
 
+// A BlockMask masks blocks, by their world coordinates, within the chunks
+// a ChunkMask leaves in.
+type BlockMask interface {
+	IsBlockMasked(x, y, z int) bool
+}
+
 type RectangeChunkMask struct {
 	x0, z0, x1, z1 int
 }
@@ -17,3 +32,217 @@ type AllChunksMask struct{}
 func (m *AllChunksMask) IsMasked(x, z int) bool {
 	return false
 }
+
+// IntersectChunkMask masks every chunk that any of its masks do.
+type IntersectChunkMask []ChunkMask
+
+func (m IntersectChunkMask) IsMasked(x, z int) bool {
+	for _, mask := range m {
+		if mask.IsMasked(x, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// IntersectBlockMask masks every block that any of its masks do.
+type IntersectBlockMask []BlockMask
+
+func (m IntersectBlockMask) String() string {
+	var s = "intersect"
+	for _, mask := range m {
+		s += fmt.Sprintf(" %v", mask)
+	}
+	return s
+}
+
+func (m IntersectBlockMask) IsBlockMasked(x, y, z int) bool {
+	for _, mask := range m {
+		if mask.IsBlockMasked(x, y, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnionChunkMask masks only the chunks that all of its masks do.
+type UnionChunkMask []ChunkMask
+
+func (m UnionChunkMask) IsMasked(x, z int) bool {
+	for _, mask := range m {
+		if !mask.IsMasked(x, z) {
+			return false
+		}
+	}
+	return true
+}
+
+// DifferenceChunkMask masks the chunks that keep masks and those that
+// remove doesn't.
+type DifferenceChunkMask struct {
+	keep, remove ChunkMask
+}
+
+func (m *DifferenceChunkMask) IsMasked(x, z int) bool {
+	return m.keep.IsMasked(x, z) || !m.remove.IsMasked(x, z)
+}
+
+// The shape masks below work in block coordinates and leave in a chunk
+// when the middle of the chunk is inside the shape.
+
+func chunkMiddle(x, z int) (float64, float64) {
+	return float64(x*16 + 8), float64(z*16 + 8)
+}
+
+// CircleChunkMask masks the chunks farther than r blocks from x,z.
+type CircleChunkMask struct {
+	x, z, r float64
+}
+
+func (m *CircleChunkMask) IsMasked(x, z int) bool {
+	var mx, mz = chunkMiddle(x, z)
+	var dx, dz = mx - m.x, mz - m.z
+	return dx*dx+dz*dz > m.r*m.r
+}
+
+// PolygonChunkMask masks the chunks outside a polygon, given by its corners
+// in order. Where the polygon crosses itself, the areas it covers an even
+// number of times are outside.
+type PolygonChunkMask struct {
+	xs, zs []float64
+}
+
+func (m *PolygonChunkMask) IsMasked(x, z int) bool {
+	var mx, mz = chunkMiddle(x, z)
+	var inside = false
+	for i, j := 0, len(m.xs)-1; i < len(m.xs); j, i = i, i+1 {
+		if (m.zs[i] > mz) != (m.zs[j] > mz) && mx < (m.xs[j]-m.xs[i])*(mz-m.zs[i])/(m.zs[j]-m.zs[i])+m.xs[i] {
+			inside = !inside
+		}
+	}
+	return !inside
+}
+
+// ListChunkMask masks every chunk not in the list.
+type ListChunkMask map[uint64]bool
+
+func (m ListChunkMask) IsMasked(x, z int) bool {
+	return !m[betaChunkPoolKey(x, z)]
+}
+
+// readChunkList reads a ListChunkMask from a file with one "x,z" chunk
+// coordinate per line. Blank lines and lines starting with # are skipped.
+func readChunkList(filename string) (ListChunkMask, os.Error) {
+	var data, readErr = ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var m = make(ListChunkMask)
+	for i, line := range strings.Split(string(data), "\n", -1) {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var coords = strings.Split(line, ",", 2)
+		if len(coords) != 2 {
+			return nil, os.NewError(fmt.Sprintf("%s:%d: want x,z", filename, i+1))
+		}
+		var (
+			x, xErr = strconv.Atoi(strings.TrimSpace(coords[0]))
+			z, zErr = strconv.Atoi(strings.TrimSpace(coords[1]))
+		)
+		if xErr != nil || zErr != nil {
+			return nil, os.NewError(fmt.Sprintf("%s:%d: want x,z", filename, i+1))
+		}
+		m[betaChunkPoolKey(x, z)] = true
+	}
+	return m, nil
+}
+
+// ModifiedChunkMask masks chunks last saved before after or, if before is
+// not 0, at or after before. Times are seconds since the Unix epoch. Chunks
+// with no timestamp count as saved at time 0.
+type ModifiedChunkMask struct {
+	timestamps    ChunkTimestamper
+	after, before int64
+}
+
+func (m *ModifiedChunkMask) IsMasked(x, z int) bool {
+	var timestamp, err = m.timestamps.ChunkTimestamp(x, z)
+	if err != nil {
+		return true
+	}
+	var t = timestamp.Seconds()
+	return t < m.after || (m.before != 0 && t >= m.before)
+}
+
+// BoxMask masks every block outside a box, given by inclusive corners in
+// block coordinates, and every chunk holding none of the box.
+type BoxMask struct {
+	x0, y0, z0, x1, y1, z1 int
+}
+
+func NewBoxMask(x0, y0, z0, x1, y1, z1 int) *BoxMask {
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	if z0 > z1 {
+		z0, z1 = z1, z0
+	}
+	return &BoxMask{x0, y0, z0, x1, y1, z1}
+}
+
+func (m *BoxMask) IsMasked(x, z int) bool {
+	return x*16+15 < m.x0 || x*16 > m.x1 || z*16+15 < m.z0 || z*16 > m.z1
+}
+
+func (m *BoxMask) IsBlockMasked(x, y, z int) bool {
+	return x < m.x0 || x > m.x1 || y < m.y0 || y > m.y1 || z < m.z0 || z > m.z1
+}
+
+// parseBox reads a box given as "x1,y1,z1,x2,y2,z2".
+func parseBox(s string) (*BoxMask, os.Error) {
+	var fields = strings.Split(s, ",", -1)
+	if len(fields) != 6 {
+		return nil, os.NewError("-box wants x1,y1,z1,x2,y2,z2, not " + s)
+	}
+	var coords [6]int
+	for i, field := range fields {
+		var n, err = strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, os.NewError("-box wants x1,y1,z1,x2,y2,z2, not " + s)
+		}
+		coords[i] = n
+	}
+	return NewBoxMask(coords[0], coords[1], coords[2], coords[3], coords[4], coords[5]), nil
+}
+
+// parseChunkTime reads a time given as a date, "2011-06-30", a date and
+// time, "2011-06-30T18:00:00Z", or an age, "7d" or "12h", and returns it in
+// seconds since the Unix epoch.
+func parseChunkTime(s string) (int64, os.Error) {
+	if len(s) > 1 && (s[len(s)-1] == 'd' || s[len(s)-1] == 'h') {
+		var n, err = strconv.Atoi64(s[:len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		var unit int64 = 60 * 60
+		if s[len(s)-1] == 'd' {
+			unit *= 24
+		}
+		return time.Seconds() - n*unit, nil
+	}
+
+	var t, err = time.Parse("2006-01-02", s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return 0, os.NewError("Can't read " + s + " as a date, a date and time or an age such as 7d")
+	}
+	return t.Seconds(), nil
+}
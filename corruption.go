@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"json"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// A CorruptionReport collects the region files and chunks a world couldn't
+// be read from, so that an export can carry on without them. A strict
+// report also hands each problem straight back, to stop at the first.
+type CorruptionReport struct {
+	mutex   sync.Mutex
+	strict  bool
+	Entries []Corruption
+}
+
+// Corruption is one unreadable region file or chunk. Chunk is "x,z", or
+// empty when the whole region or file is affected.
+type Corruption struct {
+	Region string
+	Chunk  string
+	Reason string
+}
+
+func NewCorruptionReport(strict bool) *CorruptionReport {
+	return &CorruptionReport{strict: strict}
+}
+
+// Add records a problem with a region file or chunk. Region is the path of
+// the file. It returns err if the report is strict and nil otherwise.
+func (r *CorruptionReport) Add(region, chunk string, err os.Error) os.Error {
+	r.mutex.Lock()
+	r.Entries = append(r.Entries, Corruption{region, chunk, err.String()})
+	r.mutex.Unlock()
+
+	if r.strict {
+		return err
+	}
+	return nil
+}
+
+// AddChunk records a chunk that failed to load.
+func (r *CorruptionReport) AddChunk(x, z int, err os.Error) os.Error {
+	var region = ""
+	if regionErr, isRegionErr := err.(*RegionError); isRegionErr {
+		region = regionErr.Region
+		err = regionErr.Err
+	}
+	return r.Add(region, fmt.Sprintf("%v,%v", x, z), err)
+}
+
+func (r *CorruptionReport) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.Entries)
+}
+
+// PrintSummary prints how many regions and chunks were skipped for each
+// reason.
+func (r *CorruptionReport) PrintSummary() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.Entries) == 0 {
+		return
+	}
+
+	var reasons = make(map[string]int)
+	var regions, chunks = 0, 0
+	for _, entry := range r.Entries {
+		reasons[entry.Reason]++
+		if entry.Chunk == "" {
+			regions++
+		} else {
+			chunks++
+		}
+	}
+
+	var sorted = make([]string, 0, len(reasons))
+	for reason := range reasons {
+		sorted = append(sorted, reason)
+	}
+	sort.SortStrings(sorted)
+
+	fmt.Printf("Skipped %d unreadable files and %d unreadable chunks:\n", regions, chunks)
+	for _, reason := range sorted {
+		fmt.Printf("  %5d %v\n", reasons[reason], reason)
+	}
+}
+
+// WriteJSON writes the entries to filename as a JSON array.
+func (r *CorruptionReport) WriteJSON(filename string) os.Error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var jsonBytes, marshalErr = json.MarshalIndent(r.Entries, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return ioutil.WriteFile(filename, jsonBytes, 0666)
+}
+
+// PrintTable prints every entry, one to a line.
+func (r *CorruptionReport) PrintTable() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.Entries) == 0 {
+		return
+	}
+
+	fmt.Printf("%-24s %-14s %s\n", "Region", "Chunk", "Problem")
+	for _, entry := range r.Entries {
+		fmt.Printf("%-24s %-14s %s\n", path.Base(entry.Region), entry.Chunk, entry.Reason)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path"
+)
+
+// A Dimension is one of the worlds inside a save, and the defaults that
+// suit exporting it.
+type Dimension struct {
+	Name  string
+	Dir   string // Relative to the save directory
+	Roof  int    // Bottom of the bedrock roof, or 0 if there isn't one
+	Scale int    // Overworld blocks to each of the dimension's blocks, or 0 if they don't line up
+}
+
+var dimensions = map[string]*Dimension{
+	"overworld": &Dimension{"overworld", "", 0, 1},
+	"nether":    &Dimension{"nether", "DIM-1", 123, 8},
+	"end":       &Dimension{"end", "DIM1", 0, 0},
+}
+
+// LookupDimension returns the dimension named by -dim: overworld, nether,
+// end, or the path of a dimension folder inside the save, such as the DIMn
+// folders mods add.
+func LookupDimension(name string) *Dimension {
+	var dim, known = dimensions[name]
+	if !known {
+		dim = &Dimension{name, path.Clean(name), 0, 0}
+	}
+	return dim
+}
+
+// Path returns the dimension's directory within the save at worldDir.
+func (d *Dimension) Path(worldDir string) string {
+	return path.Join(worldDir, d.Dir)
+}
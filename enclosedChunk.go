@@ -3,6 +3,7 @@ package main
 type EnclosingSides [4]*ChunkSide
 type EnclosedChunk struct {
 	xPos, zPos int
+	minY       int
 	blocks     Blocks
 	enclosing  EnclosingSides
 }
@@ -11,24 +12,37 @@ func (s *EnclosingSides) side(i int) *ChunkSide {
 	return (*s)[i]
 }
 
+// Get takes y relative to the bottom of the chunk, the same as the index
+// into one of its block columns. With -cap, blocks cut off by the
+// selection read as air so that the cut is closed over.
 func (e *EnclosedChunk) Get(x, y, z int) (blockId uint16) {
 	switch {
 	case y < 0 && hideBottom:
 		blockId = 7 // Bedrock
 	case y < 0 && !hideBottom:
-	case y > 127:
+	case y >= e.blocks.Height() || (capCuts && e.IsClipped(x, y, z)):
 		blockId = 0
 	case x == -1:
-		blockId = e.enclosing.side(0).BlockId(z, y)
+		blockId = e.enclosing.side(0).BlockId(z, y+e.minY)
 	case x == 16:
-		blockId = e.enclosing.side(1).BlockId(z, y)
+		blockId = e.enclosing.side(1).BlockId(z, y+e.minY)
 	case z == -1:
-		blockId = e.enclosing.side(2).BlockId(x, y)
+		blockId = e.enclosing.side(2).BlockId(x, y+e.minY)
 	case z == 16:
-		blockId = e.enclosing.side(3).BlockId(x, y)
+		blockId = e.enclosing.side(3).BlockId(x, y+e.minY)
 	default:
 		blockId = e.blocks.Get(x, y, z)
 	}
 
 	return
 }
+
+// IsClipped reports whether the block is outside the -y, -ymax and -box
+// selection. It takes coordinates as Get does.
+func (e *EnclosedChunk) IsClipped(x, y, z int) bool {
+	var wy = y + e.minY
+	if wy < yMin || wy > yMax {
+		return true
+	}
+	return blockMask != nil && blockMask.IsBlockMasked(x+e.xPos*16, wy, z+e.zPos*16)
+}
@@ -5,8 +5,14 @@ import (
 	"io"
 )
 
+// Exported meshes are shifted so the block corner at meshOrigin sits at
+// 0,0,0, whatever the chunk's MinY. Unless -center moves it, that is the
+// surface of the sea (the top of y 63 in every format) above block 0,0.
+var meshOriginX, meshOriginY, meshOriginZ = 0, 64, 0
+
 type Faces struct {
 	xPos, zPos int
+	minY       int
 	count      int
 
 	vertexes Vertexes
@@ -15,18 +21,19 @@ type Faces struct {
 }
 
 func (fs *Faces) ProcessChunk(enclosed *EnclosedChunk, w io.Writer) (count int) {
-	fs.Clean(enclosed.xPos, enclosed.zPos)
+	fs.Clean(enclosed.xPos, enclosed.zPos, enclosed.minY, enclosed.blocks.Height())
 	fs.processBlocks(enclosed, fs)
 	fs.Write(w)
 	return len(fs.faces)
 }
 
-func (fs *Faces) Clean(xPos, zPos int) {
+func (fs *Faces) Clean(xPos, zPos, minY, height int) {
 	fs.xPos = xPos
 	fs.zPos = zPos
+	fs.minY = minY
 
-	if fs.vertexes == nil {
-		fs.vertexes = make([]int16, (128+1)*(16+1)*(16+1))
+	if len(fs.vertexes) != (height+1)*(16+1)*(16+1) {
+		fs.vertexes = make([]int32, (height+1)*(16+1)*(16+1))
 	} else {
 		fs.vertexes.Clear()
 	}
@@ -54,7 +61,7 @@ func (fs *Faces) AddFace(blockId uint16, v1, v2, v3, v4 Vertex) {
 
 func (fs *Faces) Write(w io.Writer) {
 	fs.vertexes.Number()
-	var vc = int16(fs.vertexes.Print(w, fs.xPos, fs.zPos))
+	var vc = int32(fs.vertexes.Print(w, fs.xPos, fs.zPos, fs.minY))
 
 	var blockIds = make([]uint16, 0, 16)
 	for _, face := range fs.faces {
@@ -82,10 +89,20 @@ func (fs *Faces) Write(w io.Writer) {
 	}
 }
 
-type Vertexes []int16
+// Vertexes counts the faces using each vertex of a chunk and then, once
+// numbered, holds each one's number in the output. A chunk 384 blocks tall
+// can use more vertexes than an int16 holds.
+type Vertexes []int32
+
+// Stride is the number of vertexes in each column, one more than the height
+// of the chunk they belong to.
+func (vs *Vertexes) Stride() int {
+	return len(*vs) / (17 * 17)
+}
 
 func (vs *Vertexes) Index(x, y, z int) int {
-	return y + (z*129 + (x * 129 * 17))
+	var stride = vs.Stride()
+	return y + (z*stride + (x * stride * 17))
 }
 
 func (vs *Vertexes) Use(v Vertex) int {
@@ -100,7 +117,7 @@ func (vs *Vertexes) Release(v Vertex) int {
 	return i
 }
 
-func (vs *Vertexes) Get(i int) int16 {
+func (vs *Vertexes) Get(i int) int32 {
 	return (*vs)[i]
 }
 
@@ -111,7 +128,7 @@ func (vs *Vertexes) Clear() {
 }
 
 func (vs *Vertexes) Number() {
-	var count int16 = 0
+	var count int32 = 0
 	for i, references := range *vs {
 		if references != 0 {
 			count++
@@ -122,24 +139,26 @@ func (vs *Vertexes) Number() {
 	}
 }
 
-func (vs *Vertexes) Print(w io.Writer, xPos, zPos int) (count int) {
+func (vs *Vertexes) Print(w io.Writer, xPos, zPos, minY int) (count int) {
 	var buf = make([]byte, 64)
 	copy(buf[0:2], "v ")
 
+	var stride = vs.Stride()
+
 	count = 0
-	for i := 0; i < len(*vs); i += 129 {
-		var x, z = (i / 129) / 17, (i / 129) % 17
+	for i := 0; i < len(*vs); i += stride {
+		var x, z = (i / stride) / 17, (i / stride) % 17
 
-		var column = (*vs)[i : i+129]
+		var column = (*vs)[i : i+stride]
 		for y, offset := range column {
 			if offset != -1 {
 
 				count++
 
 				var (
-					xa = x + xPos*16
-					ya = y - 64
-					za = z + zPos*16
+					xa = x + xPos*16 - meshOriginX
+					ya = y + minY - meshOriginY
+					za = z + zPos*16 - meshOriginZ
 				)
 
 				buf = buf[:2]
@@ -245,14 +264,15 @@ func (r *blockRun) Update(faces AddFacer, nr *blockRun, flag bool) {
 }
 
 func (fs *Faces) processBlocks(enclosedChunk *EnclosedChunk, faces AddFacer) {
-	for i := 0; i < len(enclosedChunk.blocks); i += 128 {
-		var x, z = (i / 128) / 16, (i / 128) % 16
+	var height = enclosedChunk.blocks.Height()
+	for i := 0; i < len(enclosedChunk.blocks); i += height {
+		var x, z = (i / height) / 16, (i / height) % 16
 
 		var r1, r2, r3, r4 blockRun
 
-		var column = BlockColumn(enclosedChunk.blocks[i : i+128])
+		var column = BlockColumn(enclosedChunk.blocks[i : i+height])
 		for y, blockId := range column {
-			if y < yMin {
+			if enclosedChunk.IsClipped(x, y, z) {
 				continue
 			}
 
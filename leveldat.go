@@ -0,0 +1,202 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"math"
+	"nbt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// levelDat holds the parts of level.dat mcobj uses.
+type levelDat struct {
+	Data struct {
+		SpawnX, SpawnY, SpawnZ int
+		Player                 *playerDat // Only in single player saves
+	}
+}
+
+// playerDat holds the parts of a player's data mcobj uses.
+type playerDat struct {
+	Pos       []float64
+	Dimension nbt.Tag // An int before 1.16, a name such as "minecraft:the_nether" after
+}
+
+// localPlayerName names the player kept in level.dat by single player saves.
+const localPlayerName = "local"
+
+// A Player is where a player in a save last was.
+type Player struct {
+	Name      string
+	X, Y, Z   float64
+	Dimension *Dimension // nil if the player is in a dimension mcobj doesn't know
+}
+
+// Block returns the coordinates of the block the player stands in.
+func (p *Player) Block() (x, y, z int) {
+	return int(math.Floor(p.X)), int(math.Floor(p.Y)), int(math.Floor(p.Z))
+}
+
+func (p *Player) String() string {
+	var dimName = "unknown dimension"
+	if p.Dimension != nil {
+		dimName = p.Dimension.Name
+	}
+	var x, y, z = p.Block()
+	return fmt.Sprintf("%-32s %7d %4d %7d  %s", p.Name, x, y, z, dimName)
+}
+
+// readLevelDat reads the gzipped level.dat at the top of the save in
+// worldDir.
+func readLevelDat(worldDir string) (*levelDat, os.Error) {
+	var level = new(levelDat)
+	var err = readGzipNbt(path.Join(worldDir, "level.dat"), level)
+	if err != nil {
+		return nil, err
+	}
+	return level, nil
+}
+
+// readGzipNbt unmarshals the gzipped NBT file filename into v.
+func readGzipNbt(filename string, v interface{}) os.Error {
+	var file, openErr = os.Open(filename, os.O_RDONLY, 0666)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+
+	var r, gzipErr = gzip.NewReader(file)
+	if gzipErr != nil {
+		return gzipErr
+	}
+	defer r.Close()
+
+	return nbt.Unmarshal(r, v)
+}
+
+// ReadPlayers returns the players of the save in worldDir: the one in
+// level.dat, named local, and those in the players folder, named by their
+// file, and the playerdata folder, named by their UUID. Player files that
+// can't be read are left out.
+func ReadPlayers(worldDir string) ([]*Player, os.Error) {
+	var level, levelErr = readLevelDat(worldDir)
+	if levelErr != nil {
+		return nil, levelErr
+	}
+
+	var players []*Player
+	if level.Data.Player != nil {
+		var player = newPlayer(localPlayerName, level.Data.Player)
+		if player != nil {
+			players = append(players, player)
+		}
+	}
+
+	for _, dirname := range []string{"players", "playerdata"} {
+		var dir, openErr = os.Open(path.Join(worldDir, dirname), os.O_RDONLY, 0666)
+		if openErr != nil {
+			continue
+		}
+		var filenames, readErr = dir.Readdirnames(-1)
+		dir.Close()
+		if readErr != nil {
+			continue
+		}
+		sort.SortStrings(filenames)
+
+		for _, filename := range filenames {
+			if path.Ext(filename) != ".dat" {
+				continue
+			}
+			var data = new(playerDat)
+			var err = readGzipNbt(path.Join(worldDir, dirname, filename), data)
+			if err != nil {
+				continue
+			}
+			var player = newPlayer(filename[:len(filename)-len(".dat")], data)
+			if player != nil {
+				players = append(players, player)
+			}
+		}
+	}
+	return players, nil
+}
+
+// FindPlayer returns the player called name in the save in worldDir. Names
+// are matched without regard to case.
+func FindPlayer(worldDir, name string) (*Player, os.Error) {
+	var players, err = ReadPlayers(worldDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names = make([]string, 0, len(players))
+	for _, player := range players {
+		if strings.ToLower(player.Name) == strings.ToLower(name) {
+			return player, nil
+		}
+		names = append(names, player.Name)
+	}
+	return nil, os.NewError("No player " + name + " in " + worldDir + ". Known players: " + strings.Join(names, ", "))
+}
+
+func newPlayer(name string, data *playerDat) *Player {
+	if len(data.Pos) != 3 {
+		return nil
+	}
+	return &Player{name, data.Pos[0], data.Pos[1], data.Pos[2], playerDimension(data.Dimension)}
+}
+
+// playerDimension returns the dimension a player's Dimension tag names.
+func playerDimension(tag nbt.Tag) *Dimension {
+	if tag == nil {
+		return dimensions["overworld"]
+	}
+	if name, isString := tag.(nbt.String); isString {
+		switch name {
+		case "minecraft:overworld":
+			return dimensions["overworld"]
+		case "minecraft:the_nether":
+			return dimensions["nether"]
+		case "minecraft:the_end":
+			return dimensions["end"]
+		}
+		return nil
+	}
+	var id, _ = nbt.Int64(tag)
+	switch id {
+	case 0:
+		return dimensions["overworld"]
+	case -1:
+		return dimensions["nether"]
+	case 1:
+		return dimensions["end"]
+	}
+	return nil
+}
+
+// ReadSpawn returns the world spawn point of the save in worldDir, in the
+// block coordinates of dim. The spawn point in level.dat is the overworld's,
+// so for dimensions that don't line up with the overworld, such as the End,
+// whose main island is at 0,0, it returns the origin at sea level instead.
+func ReadSpawn(worldDir string, dim *Dimension) (x, y, z int, err os.Error) {
+	if dim.Scale == 0 {
+		return 0, 64, 0, nil
+	}
+	var level, readErr = readLevelDat(worldDir)
+	if readErr != nil {
+		return 0, 0, 0, readErr
+	}
+	var spawn = &level.Data
+	return floorDiv(spawn.SpawnX, dim.Scale), spawn.SpawnY, floorDiv(spawn.SpawnZ, dim.Scale), nil
+}
+
+func floorDiv(n, d int) int {
+	if n < 0 {
+		return -((-n + d - 1) / d)
+	}
+	return n / d
+}
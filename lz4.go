@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Region files with compression type 4 hold chunks in the block stream
+// format of lz4-java's LZ4BlockOutputStream: a run of blocks, each with a
+// 21 byte header, ending in an empty block.
+const (
+	lz4BlockMagic      = "LZ4Block"
+	lz4BlockHeaderSize = len(lz4BlockMagic) + 13
+	lz4MethodRaw       = 0x10
+	lz4MethodLZ4       = 0x20
+	lz4MaxBlockSize    = 1 << 25
+)
+
+var (
+	LZ4FormatError = os.NewError("Corrupt LZ4 block stream")
+)
+
+type lz4BlockReader struct {
+	r          io.Reader
+	block      []byte // Decompressed block
+	pending    []byte // The part of block not yet read
+	compressed []byte
+	done       bool
+}
+
+func newLZ4BlockReader(r io.Reader) *lz4BlockReader {
+	return &lz4BlockReader{r: r}
+}
+
+func (l *lz4BlockReader) Read(p []byte) (int, os.Error) {
+	for len(l.pending) == 0 {
+		if l.done {
+			return 0, os.EOF
+		}
+		var err = l.nextBlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var n = copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// nextBlock reads and decompresses one block. The xxhash checksum in the
+// header isn't checked; a bad block almost always fails to decode anyway.
+func (l *lz4BlockReader) nextBlock() os.Error {
+	var header [lz4BlockHeaderSize]byte
+	var _, err = io.ReadFull(l.r, header[:])
+	if err != nil {
+		if err == os.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if string(header[:len(lz4BlockMagic)]) != lz4BlockMagic {
+		return LZ4FormatError
+	}
+
+	var (
+		method           = header[8] & 0xf0
+		compressedSize   = int(int32(binary.LittleEndian.Uint32(header[9:13])))
+		decompressedSize = int(int32(binary.LittleEndian.Uint32(header[13:17])))
+	)
+	if compressedSize < 0 || compressedSize > lz4MaxBlockSize || decompressedSize < 0 || decompressedSize > lz4MaxBlockSize {
+		return LZ4FormatError
+	}
+	if decompressedSize == 0 {
+		l.done = true
+		return nil
+	}
+
+	if cap(l.compressed) < compressedSize {
+		l.compressed = make([]byte, compressedSize)
+	}
+	l.compressed = l.compressed[:compressedSize]
+	_, err = io.ReadFull(l.r, l.compressed)
+	if err != nil {
+		return err
+	}
+
+	if cap(l.block) < decompressedSize {
+		l.block = make([]byte, decompressedSize)
+	}
+	l.block = l.block[:decompressedSize]
+
+	switch method {
+	case lz4MethodRaw:
+		if compressedSize != decompressedSize {
+			return LZ4FormatError
+		}
+		copy(l.block, l.compressed)
+	case lz4MethodLZ4:
+		var n, decodeErr = lz4DecodeBlock(l.compressed, l.block)
+		if decodeErr != nil {
+			return decodeErr
+		}
+		if n != decompressedSize {
+			return LZ4FormatError
+		}
+	default:
+		return LZ4FormatError
+	}
+
+	l.pending = l.block
+	return nil
+}
+
+// lz4DecodeBlock decompresses one raw LZ4 block into dst, returning the
+// number of bytes written.
+func lz4DecodeBlock(src, dst []byte) (int, os.Error) {
+	var si, di = 0, 0
+	for si < len(src) {
+		var token = src[si]
+		si++
+
+		var literals = int(token >> 4)
+		if literals == 15 {
+			var more, err = lz4ExtendLength(src, &si)
+			if err != nil {
+				return di, err
+			}
+			literals += more
+		}
+		if si+literals > len(src) || di+literals > len(dst) {
+			return di, LZ4FormatError
+		}
+		copy(dst[di:], src[si:si+literals])
+		si += literals
+		di += literals
+
+		// The last sequence is literals only.
+		if si == len(src) {
+			break
+		}
+
+		if si+2 > len(src) {
+			return di, LZ4FormatError
+		}
+		var offset = int(src[si]) | int(src[si+1])<<8
+		si += 2
+		if offset == 0 || offset > di {
+			return di, LZ4FormatError
+		}
+
+		var matchLength = int(token&0x0f) + 4
+		if token&0x0f == 15 {
+			var more, err = lz4ExtendLength(src, &si)
+			if err != nil {
+				return di, err
+			}
+			matchLength += more
+		}
+		if di+matchLength > len(dst) {
+			return di, LZ4FormatError
+		}
+
+		// Matches may overlap the bytes they produce, so copy a byte at a
+		// time.
+		for i := 0; i < matchLength; i++ {
+			dst[di] = dst[di-offset]
+			di++
+		}
+	}
+	return di, nil
+}
+
+// lz4ExtendLength reads the 255-continued length bytes that follow a
+// saturated token nibble.
+func lz4ExtendLength(src []byte, si *int) (int, os.Error) {
+	var length = 0
+	for {
+		if *si >= len(src) {
+			return length, LZ4FormatError
+		}
+		var b = src[*si]
+		*si++
+		length += int(b)
+		if b != 255 {
+			return length, nil
+		}
+	}
+	return length, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseMask reads the -mask expression s, or the file named by s after an
+// @. An expression combines shapes, in block coordinates unless noted:
+//
+//	circle(x, z, r)
+//	rect(x1, z1, x2, z2)
+//	polygon(x1, z1, x2, z2, x3, z3, ...)
+//	chunks(file)    chunk coordinates listed in a file, one x,z per line
+//	all
+//
+// with a + b for their union, a & b for their intersection and a - b for a
+// without b. & binds tighter than + and -, and parentheses group. In a file,
+// lines may be split anywhere and # starts a comment running to the end of
+// the line.
+func parseMask(s string) (ChunkMask, os.Error) {
+	if strings.HasPrefix(s, "@") {
+		var data, readErr = ioutil.ReadFile(s[1:])
+		if readErr != nil {
+			return nil, readErr
+		}
+		var lines = strings.Split(string(data), "\n", -1)
+		for i, line := range lines {
+			var comment = strings.Index(line, "#")
+			if comment != -1 {
+				lines[i] = line[:comment]
+			}
+		}
+		s = strings.Join(lines, " ")
+	}
+
+	var p = &maskParser{s, 0}
+	var mask, err = p.expr()
+	if err == nil && p.skipSpace() != 0 {
+		err = p.error("unexpected " + string(p.s[p.pos]))
+	}
+	return mask, err
+}
+
+type maskParser struct {
+	s   string
+	pos int
+}
+
+// skipSpace moves past any white space and returns the next byte, or 0 at
+// the end.
+func (p *maskParser) skipSpace() byte {
+	for p.pos < len(p.s) && strings.IndexRune(" \t\r\n", int(p.s[p.pos])) != -1 {
+		p.pos++
+	}
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *maskParser) error(message string) os.Error {
+	return os.NewError("-mask: " + message + " at column " + strconv.Itoa(p.pos+1) + " of " + p.s)
+}
+
+func (p *maskParser) expect(c byte) os.Error {
+	if p.skipSpace() != c {
+		return p.error("want " + string(c))
+	}
+	p.pos++
+	return nil
+}
+
+// expr is term { ("+" | "-") term }.
+func (p *maskParser) expr() (ChunkMask, os.Error) {
+	var mask, err = p.term()
+	for err == nil {
+		var op = p.skipSpace()
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		var right ChunkMask
+		right, err = p.term()
+		if op == '+' {
+			mask = UnionChunkMask{mask, right}
+		} else {
+			mask = &DifferenceChunkMask{mask, right}
+		}
+	}
+	return mask, err
+}
+
+// term is factor { "&" factor }.
+func (p *maskParser) term() (ChunkMask, os.Error) {
+	var mask, err = p.factor()
+	for err == nil && p.skipSpace() == '&' {
+		p.pos++
+		var right ChunkMask
+		right, err = p.factor()
+		mask = IntersectChunkMask{mask, right}
+	}
+	return mask, err
+}
+
+// factor is "(" expr ")" or a shape.
+func (p *maskParser) factor() (ChunkMask, os.Error) {
+	if p.skipSpace() == '(' {
+		p.pos++
+		var mask, err = p.expr()
+		if err == nil {
+			err = p.expect(')')
+		}
+		return mask, err
+	}
+
+	var start = p.pos
+	for p.pos < len(p.s) && 'a' <= p.s[p.pos] && p.s[p.pos] <= 'z' {
+		p.pos++
+	}
+	var name = p.s[start:p.pos]
+
+	switch name {
+	case "all":
+		return &AllChunksMask{}, nil
+	case "chunks":
+		var err = p.expect('(')
+		if err != nil {
+			return nil, err
+		}
+		var end = strings.Index(p.s[p.pos:], ")")
+		if end == -1 {
+			return nil, p.error("want )")
+		}
+		var filename = strings.TrimSpace(p.s[p.pos : p.pos+end])
+		p.pos += end + 1
+		return readChunkList(filename)
+	case "circle", "rect", "polygon":
+		var args, err = p.numbers()
+		if err != nil {
+			return nil, err
+		}
+		return shapeMask(name, args, p)
+	case "":
+		return nil, p.error("want a shape")
+	}
+	p.pos = start
+	return nil, p.error("unknown shape " + name)
+}
+
+// numbers reads a parenthesised list of numbers.
+func (p *maskParser) numbers() ([]float64, os.Error) {
+	var err = p.expect('(')
+	if err != nil {
+		return nil, err
+	}
+	var numbers []float64
+	for {
+		p.skipSpace()
+		var start = p.pos
+		for p.pos < len(p.s) && strings.IndexRune("+-.0123456789", int(p.s[p.pos])) != -1 {
+			p.pos++
+		}
+		var n, numberErr = strconv.Atof64(p.s[start:p.pos])
+		if numberErr != nil {
+			p.pos = start
+			return nil, p.error("want a number")
+		}
+		numbers = append(numbers, n)
+
+		switch p.skipSpace() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return numbers, nil
+		default:
+			return nil, p.error("want , or )")
+		}
+	}
+	return nil, nil
+}
+
+func shapeMask(name string, args []float64, p *maskParser) (ChunkMask, os.Error) {
+	switch {
+	case name == "circle" && len(args) == 3:
+		return &CircleChunkMask{args[0], args[1], args[2]}, nil
+	case name == "rect" && len(args) == 4:
+		var (
+			x1, z1 = args[0], args[1]
+			x2, z2 = args[2], args[3]
+		)
+		return &PolygonChunkMask{[]float64{x1, x2, x2, x1}, []float64{z1, z1, z2, z2}}, nil
+	case name == "polygon" && len(args) >= 6 && len(args)%2 == 0:
+		var m = &PolygonChunkMask{}
+		for i := 0; i < len(args); i += 2 {
+			m.xs = append(m.xs, args[i])
+			m.zs = append(m.zs, args[i+1])
+		}
+		return m, nil
+	}
+	return nil, p.error("wrong number of coordinates for " + name)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -18,8 +20,10 @@ import (
 var (
 	out        *bufio.Writer
 	yMin       int
+	yMax       int
 	blockFaces bool
 	hideBottom bool
+	capCuts    bool
 	noColor    bool
 
 	faceCount int
@@ -29,9 +33,19 @@ var (
 	chunkLimit int
 
 	chunkMask ChunkMask
+	blockMask BlockMask
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "nbt" {
+		nbtMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		checkMain(os.Args[2:])
+		return
+	}
+
 	var cx, cz int
 	var square int
 	var rectx, rectz int
@@ -45,18 +59,35 @@ func main() {
 	var outFilename string
 	flag.IntVar(&maxProcs, "cpu", maxProcs, "Number of cores to use")
 	flag.StringVar(&outFilename, "o", defaultObjOutFilename, "Name for output file")
-	flag.IntVar(&yMin, "y", 0, "Omit all blocks below this height. 63 is sea level")
+	flag.IntVar(&yMin, "y", math.MinInt32, "Omit all blocks below this height. 63 is sea level")
+	flag.IntVar(&yMax, "ymax", math.MaxInt32, "Omit all blocks above this height. Without it the Nether roof is left out")
+	var dimName = flag.String("dim", "overworld", "Dimension to export: overworld, nether, end or a dimension folder in the save")
 	flag.BoolVar(&solidSides, "sides", false, "Solid sides, rather than showing underground")
 	flag.BoolVar(&blockFaces, "bf", false, "Don't combine adjacent faces of the same block within a column")
 	flag.BoolVar(&hideBottom, "hb", false, "Hide bottom of world")
+	var maskExpr = flag.String("mask", "", "Only chunks in this shape, such as \"circle(0,0,500) - rect(-16,-16,16,16)\", or in the shape in the file after @")
+	var pngFilename = flag.String("png", "", "Only blocks under the opaque pixels of this PNG image, such as a painted map")
+	var pngAt = flag.String("pngat", "0,0", "Block x,z under the top left pixel of the -png image")
+	var pngScale = flag.Int("pngscale", 1, "Blocks across each pixel of the -png image")
+	var box = flag.String("box", "", "Only blocks in this box, x1,y1,z1,x2,y2,z2 in block coordinates")
+	flag.BoolVar(&capCuts, "cap", false, "Close over where -box, -png, -y and -ymax cut through solid ground")
 	flag.BoolVar(&noColor, "g", false, "Omit materials")
-	flag.IntVar(&cx, "cx", 0, "Center x coordinate")
-	flag.IntVar(&cz, "cz", 0, "Center z coordinate")
+	var center = flag.String("center", "spawn", "Center the export, and the mesh origin, on the world spawn point (spawn), chunk 0,0 (origin) or a player (player:NAME). The End and other dimensions use chunk 0,0 for spawn")
+	var listPlayers = flag.Bool("players", false, "List the players in each world and where they are")
+	flag.IntVar(&cx, "cx", 0, "Center x coordinate, in chunks. Overrides -center")
+	flag.IntVar(&cz, "cz", 0, "Center z coordinate, in chunks. Overrides -center")
 	flag.IntVar(&square, "s", math.MaxInt32, "Chunk square size")
 	flag.IntVar(&rectx, "rx", math.MaxInt32, "Width(x) of rectangle size")
 	flag.IntVar(&rectz, "rz", math.MaxInt32, "Height(z) of rectangle size")
 	flag.IntVar(&faceLimit, "fk", math.MaxInt32, "Face limit (thousands of faces)")
 	flag.BoolVar(&prt, "prt", false, "Write out PRT file instead of Obj file")
+	flag.IntVar(&chunkCacheSize, "chunkcache", chunkCacheSize, "Decoded chunks to keep for reuse (0 to disable)")
+	flag.IntVar(&sideCacheMB, "sidemem", sideCacheMB, "Megabytes of chunk sides to keep in memory before spilling to disk (0 for no limit)")
+	var modifiedAfter = flag.String("after", "", "Only chunks saved since this date, date and time or age (2011-06-30, 2011-06-30T18:00:00Z, 7d)")
+	var modifiedBefore = flag.String("before", "", "Only chunks last saved before this date, date and time or age")
+	var meshCacheDir = flag.String("cache", "", "Keep each chunk's output in this directory and reuse it for unchanged chunks")
+	var strict = flag.Bool("strict", false, "Stop at the first unreadable region file or chunk")
+	var reportFilename = flag.String("report", "", "Write the unreadable region files and chunks to this JSON file")
 	var showHelp = flag.Bool("h", false, "Show Help")
 	flag.Parse()
 
@@ -75,31 +106,112 @@ func main() {
 		faceLimit *= 1000
 	}
 
-	if square != math.MaxInt32 {
-		chunkLimit = square * square
-		var h = square / 2
-		chunkMask = &RectangeChunkMask{cx - h, cz - h, cx - h + square, cz - h + square}
-	} else if rectx != math.MaxInt32 || rectz != math.MaxInt32 {
-		switch {
-		case rectx != math.MaxInt32 && rectz != math.MaxInt32:
-			chunkLimit = rectx * rectz
-			var (
-				hx = rectx / 2
-				hz = rectz / 2
-			)
-			chunkMask = &RectangeChunkMask{cx - hx, cz - hz, cx - hx + rectx, cz - hz + rectz}
-		case rectx != math.MaxInt32:
-			chunkLimit = math.MaxInt32
-			var hx = rectx / 2
-			chunkMask = &RectangeChunkMask{cx - hx, math.MinInt32, cx - hx + rectx, math.MaxInt32}
-		case rectz != math.MaxInt32:
-			chunkLimit = math.MaxInt32
-			var hz = rectz / 2
-			chunkMask = &RectangeChunkMask{math.MinInt32, cz - hz, math.MaxInt32, cz - hz + rectz}
+	if *listPlayers {
+		for i := 0; i < flag.NArg(); i++ {
+			var players, err = ReadPlayers(flag.Arg(i))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			fmt.Println(flag.Arg(i))
+			for _, player := range players {
+				fmt.Println(" ", player)
+			}
+		}
+		return
+	}
+
+	var (
+		centerOnChunk = *center == "origin"
+		playerName    string
+		dimSet        = false
+	)
+	if strings.HasPrefix(*center, "player:") {
+		playerName = (*center)[len("player:"):]
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cx", "cz":
+			centerOnChunk = true
+		case "dim":
+			dimSet = true
+		}
+	})
+	if !centerOnChunk && playerName == "" && *center != "spawn" {
+		fmt.Fprintln(os.Stderr, "-center wants spawn, origin or player:NAME, not", *center)
+		return
+	}
+
+	var after, before int64
+	if *modifiedAfter != "" {
+		var parseErr os.Error
+		after, parseErr = parseChunkTime(*modifiedAfter)
+		if parseErr != nil {
+			fmt.Fprintln(os.Stderr, parseErr)
+			return
+		}
+	}
+	if *modifiedBefore != "" {
+		var parseErr os.Error
+		before, parseErr = parseChunkTime(*modifiedBefore)
+		if parseErr != nil {
+			fmt.Fprintln(os.Stderr, parseErr)
+			return
+		}
+	}
+
+	var roofYMax = yMax == math.MaxInt32
+
+	var boxMask *BoxMask
+	if *box != "" {
+		var boxErr os.Error
+		boxMask, boxErr = parseBox(*box)
+		if boxErr != nil {
+			fmt.Fprintln(os.Stderr, boxErr)
+			return
+		}
+		blockMask = boxMask
+	}
+
+	var imageMask *ImageMask
+	if *pngFilename != "" {
+		var coords = strings.Split(*pngAt, ",", 2)
+		var x, z int
+		var atErr os.Error = os.NewError("-pngat wants x,z, not " + *pngAt)
+		if len(coords) == 2 {
+			var xErr, zErr os.Error
+			x, xErr = strconv.Atoi(strings.TrimSpace(coords[0]))
+			z, zErr = strconv.Atoi(strings.TrimSpace(coords[1]))
+			if xErr == nil && zErr == nil {
+				atErr = nil
+			}
+		}
+		if atErr != nil {
+			fmt.Fprintln(os.Stderr, atErr)
+			return
+		}
+
+		var imageErr os.Error
+		imageMask, imageErr = ReadImageMask(*pngFilename, x, z, *pngScale)
+		if imageErr != nil {
+			fmt.Fprintln(os.Stderr, imageErr)
+			return
+		}
+		if blockMask != nil {
+			blockMask = IntersectBlockMask{blockMask, imageMask}
+		} else {
+			blockMask = imageMask
+		}
+	}
+
+	var maskShape ChunkMask
+	if *maskExpr != "" {
+		var maskErr os.Error
+		maskShape, maskErr = parseMask(*maskExpr)
+		if maskErr != nil {
+			fmt.Fprintln(os.Stderr, maskErr)
+			return
 		}
-	} else {
-		chunkLimit = math.MaxInt32
-		chunkMask = &AllChunksMask{}
 	}
 
 	if prt && outFilename == defaultObjOutFilename {
@@ -119,6 +231,17 @@ func main() {
 		}
 	}
 
+	if *meshCacheDir != "" {
+		var cacheErr os.Error
+		meshCache, cacheErr = NewMeshCache(*meshCacheDir)
+		if cacheErr != nil {
+			fmt.Fprintln(os.Stderr, cacheErr)
+			return
+		}
+	}
+
+	var report = NewCorruptionReport(*strict)
+
 	for i := 0; i < flag.NArg(); i++ {
 		var dirpath = flag.Arg(i)
 		var fi, err = os.Stat(dirpath)
@@ -129,8 +252,58 @@ func main() {
 			fmt.Fprintln(os.Stderr, dirpath, "is not a directory")
 		}
 
-		var world = OpenWorld(dirpath, chunkMask)
-		var pool, poolErr = world.ChunkPool()
+		var dim = LookupDimension(*dimName)
+		switch {
+		case centerOnChunk:
+			meshOriginX, meshOriginY, meshOriginZ = cx*16, 64, cz*16
+		case playerName != "":
+			var player, playerErr = FindPlayer(dirpath, playerName)
+			if playerErr != nil {
+				fmt.Fprintln(os.Stderr, playerErr)
+				continue
+			}
+			if !dimSet && player.Dimension != nil {
+				dim = player.Dimension
+			} else if player.Dimension != dim {
+				fmt.Fprintln(os.Stderr, "Player", player.Name, "is not in the", dim.Name)
+			}
+			meshOriginX, meshOriginY, meshOriginZ = player.Block()
+			cx, cz = meshOriginX>>4, meshOriginZ>>4
+		default:
+			var spawnErr os.Error
+			meshOriginX, meshOriginY, meshOriginZ, spawnErr = ReadSpawn(dirpath, dim)
+			if spawnErr != nil {
+				fmt.Fprintln(os.Stderr, "Centering on chunk 0,0, as the spawn point can't be read:", spawnErr)
+				meshOriginX, meshOriginY, meshOriginZ = 0, 64, 0
+			}
+			cx, cz = meshOriginX>>4, meshOriginZ>>4
+		}
+
+		if roofYMax {
+			yMax = math.MaxInt32
+			if dim.Roof != 0 {
+				yMax = dim.Roof - 1
+			}
+		}
+
+		var areaMask ChunkMask
+		areaMask, chunkLimit = areaChunkMask(cx, cz, square, rectx, rectz)
+		if boxMask != nil {
+			areaMask = IntersectChunkMask{areaMask, boxMask}
+		}
+		if imageMask != nil {
+			areaMask = IntersectChunkMask{areaMask, imageMask}
+		}
+		if maskShape != nil {
+			areaMask = IntersectChunkMask{areaMask, maskShape}
+		}
+		chunkMask = areaMask
+		if after != 0 || before != 0 {
+			chunkMask = IntersectChunkMask{areaMask, &ModifiedChunkMask{OpenChunkTimestamps(dirpath, dim), after, before}}
+		}
+
+		var world = OpenWorld(dirpath, dim, chunkMask)
+		var pool, poolErr = world.ChunkPool(report)
 		if poolErr != nil {
 			fmt.Println(poolErr)
 			continue
@@ -146,18 +319,60 @@ func main() {
 		boundary.Init()
 		generator.Start(outFilename, pool.Remaining(), maxProcs, boundary)
 
-		if walkEnclosedChunks(pool, world, cx, cz, generator.GetEnclosedJobsChan()) {
+		var started, walkErr = walkEnclosedChunks(pool, world, cx, cz, generator.ChunkFields(), maxProcs, report, generator.GetEnclosedJobsChan())
+		if walkErr != nil {
+			// The last chunk will never be written, so the output is
+			// abandoned rather than waited for and closed.
+			break
+		}
+		if started {
 			<-generator.GetCompleteChan()
 		}
 
 		generator.Close()
 	}
+
+	if meshCache != nil {
+		fmt.Println(meshCache)
+	}
+	report.PrintSummary()
+	if *reportFilename != "" {
+		var writeErr = report.WriteJSON(*reportFilename)
+		if writeErr != nil {
+			fmt.Fprintln(os.Stderr, writeErr)
+		}
+	}
+}
+
+// areaChunkMask returns the mask for the -s square or -rx/-rz rectangle of
+// chunks centered on cx,cz, and the chunk limit that goes with it.
+func areaChunkMask(cx, cz, square, rectx, rectz int) (ChunkMask, int) {
+	if square != math.MaxInt32 {
+		var h = square / 2
+		return &RectangeChunkMask{cx - h, cz - h, cx - h + square, cz - h + square}, square * square
+	}
+	switch {
+	case rectx != math.MaxInt32 && rectz != math.MaxInt32:
+		var (
+			hx = rectx / 2
+			hz = rectz / 2
+		)
+		return &RectangeChunkMask{cx - hx, cz - hz, cx - hx + rectx, cz - hz + rectz}, rectx * rectz
+	case rectx != math.MaxInt32:
+		var hx = rectx / 2
+		return &RectangeChunkMask{cx - hx, math.MinInt32, cx - hx + rectx, math.MaxInt32}, math.MaxInt32
+	case rectz != math.MaxInt32:
+		var hz = rectz / 2
+		return &RectangeChunkMask{math.MinInt32, cz - hz, math.MaxInt32, cz - hz + rectz}, math.MaxInt32
+	}
+	return &AllChunksMask{}, math.MaxInt32
 }
 
 type OutputGenerator interface {
 	Start(outFilename string, total int, maxProcs int, boundary *BoundaryLocator)
 	GetEnclosedJobsChan() chan *EnclosedChunkJob
 	GetCompleteChan() chan bool
+	ChunkFields() nbt.ChunkFields
 	Close()
 }
 
@@ -166,11 +381,91 @@ type EnclosedChunkJob struct {
 	enclosed *EnclosedChunk
 }
 
-func walkEnclosedChunks(pool ChunkPool, opener ChunkOpener, cx, cz int, enclosedsChan chan *EnclosedChunkJob) bool {
+// chunkPrefetch is how many chunks ahead of the one being enclosed the walk
+// queues loads for.
+const chunkPrefetch = 64
+
+// chunkCacheSize is the default number of decoded chunks kept waiting
+// between being loaded for a neighbour's side and being enclosed.
+var chunkCacheSize = 1024
+
+// sideCacheMB is the memory the SideCache may use before it spills sides to
+// disk, or 0 for no limit.
+var sideCacheMB = 0
+
+type walkStep struct {
+	x, z int
+	last bool
+}
+
+// walkEnclosedChunks encloses chunks in order of distance from cx,cz. The
+// chunks and the neighbours whose sides they need are loaded and decoded by
+// workers goroutines, running ahead of the enclosing. It reports whether any
+// chunk was sent to enclosedsChan, and stops at the first chunk that fails
+// to load if report is strict, returning the error.
+func walkEnclosedChunks(pool ChunkPool, opener ChunkOpener, cx, cz int, fields nbt.ChunkFields, workers int, report *CorruptionReport, enclosedsChan chan *EnclosedChunkJob) (bool, os.Error) {
 	var (
-		sideCache = new(SideCache)
-		started   = false
+		sideCache  = NewSideCache(int64(sideCacheMB) << 20)
+		chunkCache = NewChunkCache(chunkCacheSize)
+		loader     = NewChunkLoader(opener, fields, chunkCache, workers)
+		steps      = make(chan walkStep, chunkPrefetch)
+		quit       = make(chan bool)
+		started    = false
 	)
+	defer loader.Close()
+	defer sideCache.Clear()
+
+	go walkChunkOrder(pool, loader, cx, cz, steps, quit)
+
+	for step := range steps {
+		loadSide(sideCache, loader, step.x-1, step.z)
+		loadSide(sideCache, loader, step.x+1, step.z)
+		loadSide(sideCache, loader, step.x, step.z-1)
+		loadSide(sideCache, loader, step.x, step.z+1)
+
+		var chunk, loadErr = loader.Load(step.x, step.z)
+		if loadErr != nil {
+			fmt.Println(loadErr)
+			var reportErr = report.AddChunk(step.x, step.z, loadErr)
+			if reportErr != nil {
+				// Wait for walkChunkOrder to stop before the loader closes.
+				close(quit)
+				for _ = range steps {
+				}
+				return started, reportErr
+			}
+		} else {
+			var enclosed = sideCache.EncloseChunk(chunk)
+			sideCache.AddChunk(chunk)
+			loader.Forget(step.x, step.z)
+			chunkCount++
+			enclosedsChan <- &EnclosedChunkJob{step.last, enclosed}
+			started = true
+		}
+	}
+
+	fmt.Println(chunkCache)
+	fmt.Println(sideCache)
+	return started, nil
+}
+
+// walkChunkOrder pops chunks from the pool in walk order, prefetching each
+// one and the neighbours loadSide will be asked for before it, and passes
+// them on to walkEnclosedChunks until quit is closed. A neighbour needs its
+// side loaded unless it has already been walked or had its side loaded.
+func walkChunkOrder(pool ChunkPool, loader *ChunkLoader, cx, cz int, steps chan walkStep, quit chan bool) {
+	var (
+		walked = make(map[uint64]bool)
+		sided  = make(map[uint64]bool)
+	)
+
+	var prefetchSide = func(x, z int) {
+		var key = betaChunkPoolKey(x, z)
+		if !walked[key] && !sided[key] && !chunkMask.IsMasked(x, z) {
+			sided[key] = true
+			loader.Prefetch(x, z)
+		}
+	}
 
 	for i := 0; moreChunks(pool.Remaining()); i++ {
 		for x := 0; x < i && moreChunks(pool.Remaining()); x++ {
@@ -181,40 +476,46 @@ func walkEnclosedChunks(pool ChunkPool, opener ChunkOpener, cx, cz int, enclosed
 				)
 
 				if pool.Pop(ax, az) {
-					loadSide(sideCache, opener, ax-1, az)
-					loadSide(sideCache, opener, ax+1, az)
-					loadSide(sideCache, opener, ax, az-1)
-					loadSide(sideCache, opener, ax, az+1)
-
-					var chunk, loadErr = loadChunk2(opener, ax, az)
-					if loadErr != nil {
-						fmt.Println(loadErr)
-					} else {
-						var enclosed = sideCache.EncloseChunk(chunk)
-						sideCache.AddChunk(chunk)
-						chunkCount++
-						enclosedsChan <- &EnclosedChunkJob{!moreChunks(pool.Remaining()), enclosed}
-						started = true
+					prefetchSide(ax-1, az)
+					prefetchSide(ax+1, az)
+					prefetchSide(ax, az-1)
+					prefetchSide(ax, az+1)
+
+					walked[betaChunkPoolKey(ax, az)] = true
+					loader.Prefetch(ax, az)
+					select {
+					case steps <- walkStep{ax, az, !moreChunks(pool.Remaining())}:
+					case <-quit:
+						close(steps)
+						return
 					}
 				}
 			}
 		}
 	}
 
-	return started
+	close(steps)
 }
 
 type Blocks []uint16
 
 type BlockColumn []uint16
 
+// Height is the number of blocks in each of the chunk's 16x16 columns: 128
+// before Anvil, up to 256 after.
+func (b *Blocks) Height() int {
+	return len(*b) / (16 * 16)
+}
+
 func (b *Blocks) Get(x, y, z int) uint16 {
-	return (*b)[y+(z*128+(x*128*16))]
+	var height = b.Height()
+	return (*b)[y+(z*height+(x*height*16))]
 }
 
 func (b *Blocks) Column(x, z int) BlockColumn {
-	var i = 128 * (z + x*16)
-	return BlockColumn((*b)[i : i+128])
+	var height = b.Height()
+	var i = height * (z + x*16)
+	return BlockColumn((*b)[i : i+height])
 }
 
 func zigzag(n int) int {
@@ -242,23 +543,31 @@ func loadChunk(filename string) (*nbt.Chunk, os.Error) {
 	return chunk, err
 }
 
-func loadChunk2(opener ChunkOpener, x, z int) (*nbt.Chunk, os.Error) {
+// loadChunk2 decodes only the parts of chunk x,z that fields asks for.
+func loadChunk2(opener ChunkOpener, x, z int, fields nbt.ChunkFields) (*nbt.Chunk, os.Error) {
 	var r, openErr = opener.OpenChunk(x, z)
 	if openErr != nil {
 		return nil, openErr
 	}
 	defer r.Close()
 
-	var chunk, nbtErr = nbt.ReadNbt(r)
+	var chunk, nbtErr = nbt.ReadChunk(r, fields)
 	if nbtErr != nil {
 		return nil, nbtErr
 	}
+	if chunk != nil && chunk.Palette != nil {
+		resolveBlockStates(chunk)
+	} else if chunk != nil {
+		reportExtendedIds(chunk)
+	}
 	return chunk, nil
 }
 
-func loadSide(sideCache *SideCache, opener ChunkOpener, x, z int) {
+// loadSide decodes the whole chunk, not just the block ids its side needs,
+// so that the loader can keep it for when the walk reaches it.
+func loadSide(sideCache *SideCache, loader *ChunkLoader, x, z int) {
 	if !sideCache.HasSide(x, z) && !chunkMask.IsMasked(x, z) {
-		var chunk, loadErr = loadChunk2(opener, x, z)
+		var chunk, loadErr = loader.Load(x, z)
 		if loadErr != nil {
 			fmt.Println(loadErr)
 		} else {
@@ -274,6 +583,10 @@ func loadBlockTypesJson(filename string) os.Error {
 		return jsonIoError
 	}
 
+	var h = sha1.New()
+	h.Write(jsonBytes)
+	blockTypesHash = hex.EncodeToString(h.Sum())
+
 	var f interface{}
 	var unmarshalError = json.Unmarshal(jsonBytes, &f)
 	if unmarshalError != nil {
@@ -293,6 +606,7 @@ func loadBlockTypesJson(filename string) os.Error {
 					transparency Transparency        = Opaque
 					empty        bool                = false
 					color        uint32
+					states       []string
 				)
 				for k, v := range fields {
 					switch k {
@@ -337,6 +651,23 @@ func loadBlockTypesJson(filename string) os.Error {
 						} else {
 							empty = false
 						}
+					case "state":
+						switch state := v.(type) {
+						case string:
+							states = append(states, state)
+						case []interface{}:
+							for _, s := range state {
+								states = append(states, s.(string))
+							}
+						}
+					}
+				}
+
+				for _, state := range states {
+					if data != 255 {
+						addBlockState(state, uint16(blockId)+uint16(data)<<8)
+					} else {
+						addBlockState(state, uint16(blockId))
 					}
 				}
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A MeshCache keeps the output for each chunk from earlier runs in a
+// directory, one file per chunk and output format, so that re-exporting a
+// world only re-meshes the chunks that changed. Each file starts with a hash
+// of everything the output depends on: the chunk's blocks, the sides of its
+// neighbours, the options and blocks.json. A chunk whose hash doesn't match
+// is meshed again and its file replaced.
+//
+// Chunk output can be spliced in as is because OBJ faces use relative
+// vertex numbers and PRT particles stand alone.
+type MeshCache struct {
+	dir   string
+	mutex sync.Mutex
+
+	hits, misses int
+}
+
+// meshCache is nil unless -cache is given.
+var meshCache *MeshCache
+
+// blockTypesHash identifies the blocks.json the materials came from.
+var blockTypesHash string
+
+func NewMeshCache(dir string) (*MeshCache, os.Error) {
+	var err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	return &MeshCache{dir: dir}, nil
+}
+
+// Key hashes everything the output for an enclosed chunk depends on.
+func (c *MeshCache) Key(e *EnclosedChunk, format string) string {
+	var h = sha1.New()
+	fmt.Fprintf(h, "%v %v %v %v %v %v %v %v %v %v %v %v %v %v\n", version, format, blockTypesHash, yMin, yMax, blockMask, capCuts, blockFaces, hideBottom, noColor, e.minY, meshOriginX, meshOriginY, meshOriginZ)
+	hashBlocks(h, e.blocks)
+	for _, side := range e.enclosing {
+		if side == nil {
+			fmt.Fprintln(h, "no side")
+			continue
+		}
+		fmt.Fprintf(h, "side %v %v %v\n", side.minY, side.height, side.outside)
+		hashBlocks(h, side.blocks)
+	}
+	return hex.EncodeToString(h.Sum())
+}
+
+func hashBlocks(h hash.Hash, blocks []uint16) {
+	var buf = make([]byte, 2*len(blocks))
+	for i, blockId := range blocks {
+		buf[2*i] = byte(blockId)
+		buf[2*i+1] = byte(blockId >> 8)
+	}
+	h.Write(buf)
+}
+
+func (c *MeshCache) filename(xPos, zPos int, format string) string {
+	return path.Join(c.dir, fmt.Sprintf("c.%v.%v.%v", xPos, zPos, format))
+}
+
+// Get returns the cached output for chunk xPos,zPos if it was made from
+// the same input, along with the count of faces or particles in it.
+func (c *MeshCache) Get(xPos, zPos int, format, key string) ([]byte, int, bool) {
+	var data, readErr = ioutil.ReadFile(c.filename(xPos, zPos, format))
+
+	var newline = bytes.Index(data, []byte{'\n'})
+	var count = 0
+	var hit = readErr == nil && newline != -1
+	if hit {
+		var header = strings.Fields(string(data[:newline]))
+		var countErr os.Error
+		if len(header) == 3 {
+			count, countErr = strconv.Atoi(header[2])
+		}
+		hit = len(header) == 3 && header[0] == "mcobj-mesh" && header[1] == key && countErr == nil
+	}
+
+	c.mutex.Lock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mutex.Unlock()
+
+	if !hit {
+		return nil, 0, false
+	}
+	return data[newline+1:], count, true
+}
+
+// Put stores the output for chunk xPos,zPos. Failing to write the cache
+// doesn't fail the export; the chunk is just meshed again next time.
+func (c *MeshCache) Put(xPos, zPos int, format, key string, data []byte, count int) {
+	var buf = bytes.NewBuffer(make([]byte, 0, len(data)+64))
+	fmt.Fprintf(buf, "mcobj-mesh %v %v\n", key, count)
+	buf.Write(data)
+
+	var writeErr = ioutil.WriteFile(c.filename(xPos, zPos, format), buf.Bytes(), 0666)
+	if writeErr != nil {
+		fmt.Fprintln(os.Stderr, "Mesh cache:", writeErr)
+	}
+}
+
+func (c *MeshCache) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return fmt.Sprintf("Mesh cache: %d chunks reused, %d meshed", c.hits, c.misses)
+}
@@ -1,11 +1,10 @@
 package nbt
 
 import (
-	"bufio"
 	"compress/gzip"
-	"fmt"
 	"io"
 	"os"
+	"strconv"
 )
 
 const (
@@ -20,17 +19,66 @@ const (
 	tagString    = 8  // { TAG_Short length; An array of bytes defining a string in UTF-8 format. The length of this array is <length> bytes }
 	tagList      = 9  // { TAG_Byte tagId; TAG_Int length; A sequential list of Tags (not Named Tags), of type <typeId>. The length of this array is <length> Tags. } Notes: All tags share the same type.
 	tagStruct    = 10 // { A sequential list of Named Tags. This array keeps going until a TAG_End is found.; TAG_End end } Notes: If there's a nested TAG_Compound within this tag, that one will also have a TAG_End, so simply reading until the next TAG_End will not work. The names of the named tags have to be unique within each TAG_Compound The order of the tags is not guaranteed.
+	tagIntArray  = 11 // { TAG_Int length; An array of signed ints (32 bits, big endian). The length of this array is <length> ints }
+	tagLongArray = 12 // { TAG_Int length; An array of signed longs (64 bits, big endian). The length of this array is <length> longs }
 )
 
 var (
 	ErrListUnknown = os.NewError("Lists of unknown type aren't supported")
+	ErrTagUnknown  = os.NewError("Tags of unknown type aren't supported")
 )
 
+// Chunk is the block content of one 16x16 column of the world, Height
+// blocks tall starting at MinY (negative since 1.18). When Palette is set
+// (1.13 and later) Blocks holds indexes into Palette rather than block ids;
+// index 0 is always air.
 type Chunk struct {
-	XPos, ZPos int
-	blocks     []byte
-	data       []byte
-	Blocks     []uint16
+	XPos, ZPos  int
+	MinY        int
+	Height      int
+	DataVersion int
+	Blocks      []uint16
+	Palette     []BlockState
+
+	paletteIndexes map[string]uint16
+}
+
+// chunkFile is the layout of a chunk file. Up to 1.17 everything is inside
+// the Level compound; from 1.18 it sits at the root.
+type chunkFile struct {
+	DataVersion int
+	Level       *chunkLevel
+	XPos        int       `nbt:"xPos"`
+	ZPos        int       `nbt:"zPos"`
+	Sections    []section `nbt:"sections"`
+}
+
+type chunkLevel struct {
+	XPos     int `nbt:"xPos"`
+	ZPos     int `nbt:"zPos"`
+	Blocks   []byte
+	Data     []byte
+	Sections []section
+}
+
+// section is one entry of an Anvil Level.Sections list. Block data in a
+// section is 16x16x16 and ordered YZX, either as Blocks/Data/Add byte
+// arrays or as a block state palette.
+type section struct {
+	Y           int8
+	Blocks      []byte
+	Data        []byte
+	Add         []byte
+	Palette     []BlockState
+	BlockStates []int64
+	States      sectionBlockStates `nbt:"block_states"`
+}
+
+// sectionBlockStates is the 1.18+ form of a section's Palette and
+// BlockStates.
+type sectionBlockStates struct {
+	Palette []BlockState `nbt:"palette"`
+	Data    []int64      `nbt:"data"`
 }
 
 func ReadDat(reader io.Reader) (*Chunk, os.Error) {
@@ -44,149 +92,228 @@ func ReadDat(reader io.Reader) (*Chunk, os.Error) {
 }
 
 func ReadNbt(reader io.Reader) (*Chunk, os.Error) {
-	return processChunk(bufio.NewReader(reader), false)
+	return ReadChunk(reader, ChunkAll)
 }
 
-func processChunk(br *bufio.Reader, readingStruct bool) (*Chunk, os.Error) {
-	var chunk *Chunk = nil
+// ReadChunk decodes only the given fields of an uncompressed chunk, skipping
+// everything else in the file unread.
+func ReadChunk(reader io.Reader, fields ChunkFields) (*Chunk, os.Error) {
+	var file chunkFile
+	var err = UnmarshalSelected(reader, &file, chunkSelectors[fields])
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		var typeId, name, err = readTag(br)
-		if err != nil {
-			if err == os.EOF {
-				break
-			}
-			return chunk, err
+	var chunk = &Chunk{DataVersion: file.DataVersion}
+	if file.Level != nil {
+		chunk.XPos, chunk.ZPos = file.Level.XPos, file.Level.ZPos
+		if len(file.Level.Sections) != 0 {
+			chunk.mergeSections(file.Level.Sections)
+		} else {
+			chunk.mergeBlocks(file.Level.Blocks, file.Level.Data)
+		}
+	} else {
+		chunk.XPos, chunk.ZPos = file.XPos, file.ZPos
+		chunk.mergeSections(file.Sections)
+	}
+	return chunk, nil
+}
+
+// mergeBlocks combines the pre-Anvil Blocks and Data arrays, which are
+// already in XZY column order.
+func (chunk *Chunk) mergeBlocks(blocks, data []byte) {
+	chunk.Height = len(blocks) / (16 * 16)
+	chunk.Blocks = make([]uint16, len(blocks))
+	for i, blockId := range blocks {
+		chunk.Blocks[i] = uint16(blockId) + (uint16(nibble(data, i)) << 8)
+	}
+}
+
+// mergeSections lays the Anvil sections out in the same XZY column order
+// as the pre-Anvil formats, from the lowest to the highest section holding
+// blocks. Sections carrying only light data are left out. The Add nibble,
+// the high bits of ids above 255, goes in the top four bits of each block,
+// above the metadata.
+func (chunk *Chunk) mergeSections(sections []section) {
+	var bottom, top = 0, 0
+	for i := range sections {
+		var s = &sections[i]
+		if !s.hasBlocks() {
+			continue
+		}
+		if int(s.Y) < bottom {
+			bottom = int(s.Y)
 		}
+		if int(s.Y)+1 > top {
+			top = int(s.Y) + 1
+		}
+	}
 
-		switch typeId {
-		case tagStruct:
-		case tagStructEnd:
-			if readingStruct {
-				return chunk, nil
-			}
-		case tagByteArray:
-			var bytes, err2 = readBytes(br)
-			if err2 != nil {
-				return chunk, err2
-			}
-			if name == "Blocks" {
-				if chunk == nil {
-					chunk = new(Chunk)
-				}
-				chunk.blocks = bytes
-			} else if name == "Data" {
-				if chunk == nil {
-					chunk = new(Chunk)
-				}
-				chunk.data = bytes
-			}
-		case tagInt8:
-			var _, err2 = readInt8(br)
-			if err2 != nil {
-				return chunk, err2
+	chunk.MinY = bottom * 16
+	chunk.Height = (top - bottom) * 16
+	chunk.Blocks = make([]uint16, 16*16*chunk.Height)
+
+	for i := range sections {
+		var s = &sections[i]
+		if !s.hasBlocks() {
+			continue
+		}
+		if s.isPaletted() {
+			chunk.mergePalettedSection(s)
+			continue
+		}
+		for i, blockId := range s.Blocks {
+			var (
+				x = i & 0xf
+				z = (i >> 4) & 0xf
+				y = int(s.Y)*16 - chunk.MinY + i>>8
+			)
+			var block = uint16(blockId) + (uint16(nibble(s.Data, i)) << 8)
+			if s.Add != nil {
+				block += uint16(nibble(s.Add, i)) << 12
 			}
-		case tagInt16:
-			var _, err2 = readInt16(br)
-			if err2 != nil {
-				return chunk, err2
+			chunk.Blocks[y+(z*chunk.Height+(x*chunk.Height*16))] = block
+		}
+	}
+}
+
+func (s *section) hasBlocks() bool {
+	return s.Blocks != nil || s.isPaletted()
+}
+
+func (s *section) isPaletted() bool {
+	return s.Palette != nil || s.States.Palette != nil
+}
+
+func nibble(nibbles []byte, i int) byte {
+	if i/2 >= len(nibbles) {
+		return 0
+	}
+	if i&1 == 1 {
+		return nibbles[i/2] >> 4
+	}
+	return nibbles[i/2] & 0xf
+}
+
+// payloadSizes holds the size of the fixed size payloads, and of the
+// elements of the array payloads.
+var payloadSizes = map[byte]int{
+	tagInt8:      1,
+	tagInt16:     2,
+	tagInt32:     4,
+	tagInt64:     8,
+	tagFloat32:   4,
+	tagFloat64:   8,
+	tagByteArray: 1,
+	tagIntArray:  4,
+	tagLongArray: 8,
+}
+
+// skipPayload reads past the payload of a tag of the given type without
+// holding on to any of it.
+func skipPayload(br *decoder, typeId byte) os.Error {
+	switch typeId {
+	case tagStructEnd:
+		return nil
+	case tagInt8, tagInt16, tagInt32, tagInt64, tagFloat32, tagFloat64:
+		if isVarInt(br, typeId) {
+			var _, err = readVarint(br)
+			return err
+		}
+		return discard(br, payloadSizes[typeId])
+	case tagByteArray, tagIntArray, tagLongArray:
+		var length, err = readLength(br)
+		if err != nil {
+			return err
+		}
+		if typeId != tagByteArray && br.format.VarInts {
+			for i := 0; i < length && err == nil; i++ {
+				_, err = readVarint(br)
 			}
-		case tagInt32:
-			var number, err2 = readInt32(br)
+			return err
+		}
+		return discard(br, length*payloadSizes[typeId])
+	case tagString:
+		var length, err = readStringLength(br)
+		if err != nil {
+			return err
+		}
+		return discard(br, length)
+	case tagList:
+		var itemTypeId, length, err = readListHeader(br)
+		if err == nil {
+			err = br.enter()
+		}
+		if err != nil {
+			return err
+		}
+		defer br.leave()
+
+		var size, fixedSize = payloadSizes[itemTypeId]
+		if fixedSize && itemTypeId != tagByteArray && itemTypeId != tagIntArray && itemTypeId != tagLongArray && !isVarInt(br, itemTypeId) {
+			return discard(br, length*size)
+		}
+		for i := 0; i < length; i++ {
+			var err2 = skipPayload(br, itemTypeId)
 			if err2 != nil {
-				return chunk, err2
+				return br.wrap(err2, "["+strconv.Itoa(i)+"]")
 			}
+		}
+		return nil
+	case tagStruct:
+		var err = br.enter()
+		if err != nil {
+			return err
+		}
+		defer br.leave()
 
-			if chunk == nil {
-				chunk = new(Chunk)
-			}
-			if name == "xPos" {
-				chunk.XPos = number
-			}
-			if name == "zPos" {
-				chunk.ZPos = number
+		for {
+			var itemTypeId, err = br.ReadByte()
+			if err != nil {
+				return err
 			}
-		case tagInt64:
-			var _, err2 = readInt64(br)
-			if err2 != nil {
-				return chunk, err2
+			if itemTypeId == tagStructEnd {
+				return nil
 			}
-		case tagFloat32:
-			var _, err2 = readInt32(br) // TODO: read floats not ints
-			if err2 != nil {
-				return chunk, err2
+			var nameLength, err2 = readStringLength(br)
+			if err2 == nil {
+				err2 = discard(br, nameLength)
 			}
-		case tagFloat64:
-			var _, err2 = readInt64(br) // TODO: read floats not ints
-			if err2 != nil {
-				return chunk, err2
+			if err2 == nil {
+				err2 = skipPayload(br, itemTypeId)
 			}
-		case tagString:
-			var _, err2 = readString(br)
 			if err2 != nil {
-				return chunk, err2
+				return err2
 			}
-		case tagList:
-			var itemTypeId, length, err2 = readListHeader(br)
-			if err2 != nil {
-				return chunk, err2
-			}
-			switch itemTypeId {
-			case tagInt8:
-				for i := 0; i < length; i++ {
-					var _, err3 = readInt8(br)
-					if err3 != nil {
-						return chunk, err3
-					}
-				}
-			case tagFloat32:
-				for i := 0; i < length; i++ {
-					var _, err3 = readInt32(br) // TODO: read float32 instead of int32
-					if err3 != nil {
-						return chunk, err3
-					}
-				}
-			case tagFloat64:
-				for i := 0; i < length; i++ {
-					var _, err3 = readInt64(br) // TODO: read float64 instead of int64
-					if err3 != nil {
-						return chunk, err3
-					}
-				}
-			case tagStruct:
-				var chunk2, err3 = processChunk(br, true)
-				if chunk == nil {
-					chunk = chunk2
-				}
-				if err3 != nil {
-					return chunk, err3
-				}
-			default:
-				fmt.Printf("# %s list todo(%v) %v\n", name, itemTypeId, length)
-				return chunk, ErrListUnknown
-			}
-		default:
-			fmt.Printf("# %s todo(%d)\n", name, typeId)
 		}
 	}
+	return ErrTagUnknown
+}
 
-	if chunk != nil {
-		chunk.Blocks = make([]uint16, len(chunk.blocks))
-		for i, blockId := range chunk.blocks {
-			var metadata byte
-			if i&1 == 1 {
-				metadata = chunk.data[i/2] >> 4
-			} else {
-				metadata = chunk.data[i/2] & 0xf
-			}
-			chunk.Blocks[i] = uint16(blockId) + (uint16(metadata) << 8)
+// isVarInt reports whether payloads of the given type are varints in the
+// stream's format.
+func isVarInt(r *decoder, typeId byte) bool {
+	return r.format.VarInts && (typeId == tagInt32 || typeId == tagInt64)
+}
+
+// discard reads and drops n bytes.
+func discard(br *decoder, n int) os.Error {
+	var scratch [512]byte
+	for n > 0 {
+		var size = n
+		if size > len(scratch) {
+			size = len(scratch)
 		}
+		var read, err = io.ReadFull(br, scratch[:size])
+		if err != nil {
+			return err
+		}
+		n -= read
 	}
-
-	return chunk, nil
+	return nil
 }
 
-func readTag(r *bufio.Reader) (byte, string, os.Error) {
+func readTag(r *decoder) (byte, string, os.Error) {
 	var typeId, err = r.ReadByte()
 	if err != nil || typeId == 0 {
 		return typeId, "", err
@@ -200,19 +327,43 @@ func readTag(r *bufio.Reader) (byte, string, os.Error) {
 	return typeId, name, nil
 }
 
-func readListHeader(r *bufio.Reader) (itemTypeId byte, length int, err os.Error) {
+func readListHeader(r *decoder) (itemTypeId byte, length int, err os.Error) {
 	length = 0
 
 	itemTypeId, err = r.ReadByte()
 	if err == nil {
-		length, err = readInt32(r)
+		length, err = readLength(r)
 	}
 
 	return
 }
 
-func readString(r *bufio.Reader) (string, os.Error) {
-	var length, err1 = readInt16(r)
+// readLength reads an array or list length, refusing negative lengths and
+// ones over the array limit.
+func readLength(r *decoder) (int, os.Error) {
+	var length, err = readInt32(r)
+	if err == nil {
+		err = r.checkLength(length)
+	}
+	return length, err
+}
+
+func readStringLength(r *decoder) (int, os.Error) {
+	if r.format.VarInts {
+		var length, err = readVarint(r)
+		if err == nil && length > 1<<31 {
+			return 0, ErrTooLong
+		}
+		if err == nil {
+			err = r.checkLength(int(length))
+		}
+		return int(length), err
+	}
+	return readIntN(r, 2)
+}
+
+func readString(r *decoder) (string, os.Error) {
+	var length, err1 = readStringLength(r)
 	if err1 != nil {
 		return "", err1
 	}
@@ -222,8 +373,8 @@ func readString(r *bufio.Reader) (string, os.Error) {
 	return string(bytes), err2
 }
 
-func readBytes(r *bufio.Reader) ([]byte, os.Error) {
-	var length, err1 = readInt32(r)
+func readBytes(r *decoder) ([]byte, os.Error) {
+	var length, err1 = readLength(r)
 	if err1 != nil {
 		return nil, err1
 	}
@@ -233,23 +384,76 @@ func readBytes(r *bufio.Reader) ([]byte, os.Error) {
 	return bytes, err2
 }
 
-func readInt8(r *bufio.Reader) (int, os.Error) {
+func readLongs(r *decoder) ([]uint64, os.Error) {
+	var length, err1 = readLength(r)
+	if err1 != nil {
+		return nil, err1
+	}
+
+	var longs = make([]uint64, length)
+	for i := range longs {
+		var long, err2 = readUint64(r)
+		if err2 != nil {
+			return longs, err2
+		}
+		longs[i] = long
+	}
+	return longs, nil
+}
+
+// readUint64 reads a long, which is a varint in the network format.
+func readUint64(r *decoder) (uint64, os.Error) {
+	if r.format.VarInts {
+		var n, err = readZigzag(r)
+		return uint64(n), err
+	}
+	return readFixed64(r)
+}
+
+// readFixed64 reads eight bytes in the stream's byte order.
+func readFixed64(r *decoder) (uint64, os.Error) {
+	var a uint64 = 0
+
+	for i := 0; i < 8; i++ {
+		var b, err = r.ReadByte()
+		if err != nil {
+			return a, err
+		}
+		if r.format.LittleEndian {
+			a |= uint64(b) << uint(8*i)
+		} else {
+			a = a<<8 + uint64(b)
+		}
+	}
+
+	return a, nil
+}
+
+func readInt8(r *decoder) (int, os.Error) {
 	return readIntN(r, 1)
 }
 
-func readInt16(r *bufio.Reader) (int, os.Error) {
+func readInt16(r *decoder) (int, os.Error) {
 	return readIntN(r, 2)
 }
 
-func readInt32(r *bufio.Reader) (int, os.Error) {
-	return readIntN(r, 4)
+// readInt32 reads an int, which is a varint in the network format.
+func readInt32(r *decoder) (int, os.Error) {
+	if r.format.VarInts {
+		var n, err = readZigzag(r)
+		return int(int32(n)), err
+	}
+	var n, err = readIntN(r, 4)
+	return int(int32(n)), err
 }
 
-func readInt64(r *bufio.Reader) (int, os.Error) {
-	return readIntN(r, 8)
+func readInt64(r *decoder) (int, os.Error) {
+	var n, err = readUint64(r)
+	return int(n), err
 }
 
-func readIntN(r *bufio.Reader, n int) (int, os.Error) {
+// readIntN reads an n byte number in the stream's byte order.
+func readIntN(r *decoder, n int) (int, os.Error) {
 	var a int = 0
 
 	for i := 0; i < n; i++ {
@@ -257,7 +461,11 @@ func readIntN(r *bufio.Reader, n int) (int, os.Error) {
 		if err != nil {
 			return a, err
 		}
-		a = a<<8 + int(b)
+		if r.format.LittleEndian {
+			a |= int(b) << uint(8*i)
+		} else {
+			a = a<<8 + int(b)
+		}
 	}
 
 	return a, nil
@@ -0,0 +1,90 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// chunkFixtures are the alpha chunk files shipped in the tree.
+var chunkFixtures = []string{"c.0.0.dat", "c.4.4.dat"}
+
+// readFixture returns the uncompressed NBT of a gzipped chunk file.
+func readFixture(filename string) ([]byte, os.Error) {
+	var file, openErr = os.Open(filename, os.O_RDONLY, 0666)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer file.Close()
+
+	var r, gzipErr = gzip.NewReader(file)
+	if gzipErr != nil {
+		return nil, gzipErr
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func benchmarkReadChunk(b *testing.B, fields ChunkFields) {
+	b.StopTimer()
+	var data, err = readFixture(chunkFixtures[1])
+	if err != nil {
+		panic(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		var _, readErr = ReadChunk(bytes.NewBuffer(data), fields)
+		if readErr != nil {
+			panic(readErr)
+		}
+	}
+}
+
+// BenchmarkReadChunk reads only the block ids, as a mesh without materials
+// does; compare it with BenchmarkReadChunkAll.
+func BenchmarkReadChunk(b *testing.B) {
+	benchmarkReadChunk(b, ChunkBlocks)
+}
+
+func BenchmarkReadChunkAll(b *testing.B) {
+	benchmarkReadChunk(b, ChunkAll)
+}
+
+// allocations returns the number of allocations and bytes allocated by n
+// reads of data.
+func allocations(data []byte, fields ChunkFields, n int) (mallocs, allocated uint64, err os.Error) {
+	runtime.GC()
+	runtime.UpdateMemStats()
+	var mallocs0, bytes0 = runtime.MemStats.Mallocs, runtime.MemStats.TotalAlloc
+	for i := 0; i < n && err == nil; i++ {
+		_, err = ReadChunk(bytes.NewBuffer(data), fields)
+	}
+	runtime.UpdateMemStats()
+	return runtime.MemStats.Mallocs - mallocs0, runtime.MemStats.TotalAlloc - bytes0, err
+}
+
+// TestReadChunkAllocations checks that leaving fields out saves the
+// allocations for them rather than decoding and dropping them.
+func TestReadChunkAllocations(t *testing.T) {
+	for _, filename := range chunkFixtures {
+		var data, err = readFixture(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var blockMallocs, blockBytes, blockErr = allocations(data, ChunkBlocks, 20)
+		var allMallocs, allBytes, allErr = allocations(data, ChunkAll, 20)
+		if blockErr != nil || allErr != nil {
+			t.Fatalf("%s: %v %v", filename, blockErr, allErr)
+		}
+		if blockBytes >= allBytes || blockMallocs > allMallocs {
+			t.Errorf("%s: blocks only took %d allocations of %d bytes, everything %d of %d bytes", filename, blockMallocs, blockBytes, allMallocs, allBytes)
+		}
+	}
+}
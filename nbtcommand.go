@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nbt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// nbtMain runs "mcobj nbt dump", which prints any NBT file or region chunk,
+// and "mcobj nbt make", which turns hand written SNBT back into NBT.
+func nbtMain(args []string) {
+	var command string
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	os.Args = append([]string{os.Args[0] + " nbt " + command}, args...)
+	switch command {
+	case "dump":
+		nbtDump()
+	case "make":
+		nbtMake()
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: mcobj nbt dump [options] file")
+		fmt.Fprintln(os.Stderr, "       mcobj nbt make [options] file.snbt")
+		os.Exit(2)
+	}
+}
+
+func nbtDump() {
+	var (
+		asJson   bool
+		compact  bool
+		subPath  string
+		chunk    string
+		maxItems int
+	)
+	flag.BoolVar(&asJson, "json", false, "Write JSON rather than SNBT")
+	flag.BoolVar(&compact, "compact", false, "Write everything on one line")
+	flag.StringVar(&subPath, "path", "", "Only print the tag at this path, such as Level.Sections[2]")
+	flag.StringVar(&chunk, "chunk", "", "Chunk x,z to print from a region file")
+	flag.IntVar(&maxItems, "max", 32, "Cut lists and arrays short after this many items (0 for all)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: mcobj nbt dump [options] file")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var tag, readErr = readNbtFile(flag.Arg(0), chunk)
+	if readErr != nil {
+		fmt.Fprintln(os.Stderr, readErr)
+		os.Exit(1)
+	}
+
+	if subPath != "" {
+		var lookupErr os.Error
+		tag, lookupErr = nbt.Lookup(tag, subPath)
+		if lookupErr != nil {
+			fmt.Fprintln(os.Stderr, lookupErr)
+			os.Exit(1)
+		}
+	}
+
+	var options = &nbt.FormatOptions{"  ", maxItems}
+	if compact {
+		options.Indent = ""
+	}
+
+	var writeErr os.Error
+	if asJson {
+		writeErr = nbt.WriteJSON(os.Stdout, tag, options)
+	} else {
+		writeErr = nbt.WriteSNBT(os.Stdout, tag, options)
+	}
+	fmt.Println()
+	if writeErr != nil {
+		fmt.Fprintln(os.Stderr, writeErr)
+		os.Exit(1)
+	}
+}
+
+func nbtMake() {
+	var (
+		outFilename string
+		gzipped     bool
+	)
+	flag.StringVar(&outFilename, "o", "a.nbt", "Name for output file")
+	flag.BoolVar(&gzipped, "gzip", true, "Gzip the output, as level.dat and alpha chunks are")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: mcobj nbt make [options] file.snbt")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var snbt, readErr = ioutil.ReadFile(flag.Arg(0))
+	if readErr != nil {
+		fmt.Fprintln(os.Stderr, readErr)
+		os.Exit(1)
+	}
+
+	var tag, parseErr = nbt.ParseSNBT(string(snbt))
+	if parseErr != nil {
+		fmt.Fprintln(os.Stderr, parseErr)
+		os.Exit(1)
+	}
+
+	var outFile, outErr = os.Open(outFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if outErr != nil {
+		fmt.Fprintln(os.Stderr, outErr)
+		os.Exit(1)
+	}
+	defer outFile.Close()
+
+	var writeErr os.Error
+	if gzipped {
+		writeErr = nbt.WriteGzip(outFile, "", tag)
+	} else {
+		writeErr = nbt.Write(outFile, "", tag)
+	}
+	if writeErr != nil {
+		fmt.Fprintln(os.Stderr, writeErr)
+		os.Exit(1)
+	}
+}
+
+// readNbtFile reads a whole NBT file, or one chunk of it when the file is a
+// region file and chunk is "x,z".
+func readNbtFile(filename, chunk string) (nbt.Tag, os.Error) {
+	var ext = path.Ext(filename)
+	if chunk != "" && (ext == ".mcr" || ext == ".mca") {
+		var coords = strings.Split(chunk, ",", 2)
+		if len(coords) != 2 {
+			return nil, os.NewError("-chunk wants x,z")
+		}
+		var (
+			x, xErr = strconv.Atoi(strings.TrimSpace(coords[0]))
+			z, zErr = strconv.Atoi(strings.TrimSpace(coords[1]))
+		)
+		if xErr != nil || zErr != nil {
+			return nil, os.NewError("-chunk wants x,z")
+		}
+
+		var r, openErr = openRegionFileChunk(filename, x, z)
+		if openErr != nil {
+			return nil, openErr
+		}
+		defer r.Close()
+
+		var _, tag, err = nbt.Read(r)
+		return tag, err
+	}
+
+	var file, openErr = os.Open(filename, os.O_RDONLY, 0666)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer file.Close()
+
+	var _, tag, err = nbt.ReadAny(file)
+	return tag, err
+}
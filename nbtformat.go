@@ -0,0 +1,120 @@
+package nbt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// A Format says how numbers and lengths are laid out in an NBT stream. The
+// tag structure is the same in every format.
+type Format struct {
+	// LittleEndian is set for Bedrock Edition files, which store numbers
+	// least significant byte first.
+	LittleEndian bool
+
+	// VarInts is set for the network flavour of Bedrock NBT, where ints,
+	// longs and array and list lengths are zigzag varints and string
+	// lengths are unsigned varints. Shorts, floats and doubles stay fixed
+	// width.
+	VarInts bool
+
+	// Limits bounds what one decode may read; nil means DefaultLimits.
+	Limits *Limits
+}
+
+var (
+	Java           = &Format{false, false, nil} // Java Edition files and region chunks
+	Bedrock        = &Format{true, false, nil}  // Bedrock level.dat and LevelDB values
+	BedrockNetwork = &Format{true, true, nil}   // Bedrock network protocol
+)
+
+var (
+	ErrVarIntOverflow = os.NewError("Varint longer than 64 bits")
+)
+
+// decoder is a buffered stream being read in a particular format. It
+// counts the bytes read and the nesting depth to enforce the format's
+// limits.
+type decoder struct {
+	*bufio.Reader
+	format *Format
+	limits *Limits
+	offset int64
+	depth  int
+}
+
+func newDecoder(reader io.Reader, format *Format) *decoder {
+	var br, isBuffered = reader.(*bufio.Reader)
+	if !isBuffered {
+		br = bufio.NewReader(reader)
+	}
+
+	var limits = format.Limits
+	if limits == nil {
+		limits = DefaultLimits
+	}
+	return &decoder{br, format, limits, 0, 0}
+}
+
+// encoder is a buffered stream being written in a particular format.
+type encoder struct {
+	*bufio.Writer
+	format *Format
+}
+
+// ReadBedrockDat reads a Bedrock level.dat, which is little-endian NBT
+// behind an eight byte header holding the storage version and the length of
+// the NBT that follows.
+func ReadBedrockDat(reader io.Reader) (version int, name string, tag Tag, err os.Error) {
+	var header [8]byte
+	_, err = io.ReadFull(reader, header[:])
+	if err != nil {
+		return
+	}
+	version = int(binary.LittleEndian.Uint32(header[0:4]))
+	var length = int64(binary.LittleEndian.Uint32(header[4:8]))
+
+	name, tag, err = Bedrock.Read(io.LimitReader(reader, length))
+	return
+}
+
+// readVarint reads an unsigned LEB128 varint.
+func readVarint(r *decoder) (uint64, os.Error) {
+	var a uint64 = 0
+
+	for shift := uint(0); shift < 64; shift += 7 {
+		var b, err = r.ReadByte()
+		if err != nil {
+			return a, err
+		}
+		a |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return a, nil
+		}
+	}
+
+	return a, ErrVarIntOverflow
+}
+
+// readZigzag reads a signed varint in zigzag encoding.
+func readZigzag(r *decoder) (int64, os.Error) {
+	var u, err = readVarint(r)
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+func writeVarint(w *encoder, n uint64) os.Error {
+	for n >= 0x80 {
+		var err = w.WriteByte(byte(n) | 0x80)
+		if err != nil {
+			return err
+		}
+		n >>= 7
+	}
+	return w.WriteByte(byte(n))
+}
+
+func writeZigzag(w *encoder, n int64) os.Error {
+	return writeVarint(w, uint64(n<<1)^uint64(n>>63))
+}
@@ -0,0 +1,124 @@
+package nbt
+
+import (
+	"fmt"
+	"os"
+)
+
+// Limits bound what a single decode may ask for, so that a corrupt length
+// prefix fails the one file rather than exhausting memory. A zero field
+// means no limit.
+type Limits struct {
+	MaxArrayLen int   // Elements in one array or list, bytes in one string
+	MaxDepth    int   // Nesting of compounds and lists
+	MaxBytes    int64 // Bytes read from the uncompressed stream
+}
+
+// DefaultLimits are used by formats that don't set their own. They are far
+// above anything Minecraft writes: the largest arrays in a chunk hold a few
+// thousand elements and Minecraft itself refuses nesting beyond 512.
+var DefaultLimits = &Limits{
+	MaxArrayLen: 1 << 24,
+	MaxDepth:    512,
+	MaxBytes:    1 << 28,
+}
+
+var (
+	ErrNegativeLength = os.NewError("Negative length")
+	ErrTooLong        = os.NewError("Length over the array limit")
+	ErrTooDeep        = os.NewError("Nesting over the depth limit")
+	ErrTooBig         = os.NewError("Stream over the byte limit")
+)
+
+// A DecodeError is returned for any failure part way through a stream. Path
+// is in the form Lookup takes and names the tag being decoded; Offset counts
+// uncompressed bytes from the start of the stream.
+type DecodeError struct {
+	Path   string
+	Offset int64
+	Err    os.Error
+}
+
+func (e *DecodeError) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf("nbt: %v at byte %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("nbt: %v at %v (byte %d)", e.Err, e.Path, e.Offset)
+}
+
+func (r *decoder) Read(p []byte) (int, os.Error) {
+	var n, err = r.Reader.Read(p)
+	r.offset += int64(n)
+	if err == nil && r.limits.MaxBytes > 0 && r.offset > r.limits.MaxBytes {
+		err = ErrTooBig
+	}
+	return n, err
+}
+
+func (r *decoder) ReadByte() (byte, os.Error) {
+	var b, err = r.Reader.ReadByte()
+	if err == nil {
+		r.offset++
+		if r.limits.MaxBytes > 0 && r.offset > r.limits.MaxBytes {
+			err = ErrTooBig
+		}
+	}
+	return b, err
+}
+
+// enter is called on starting a compound or list, and leave on finishing it.
+func (r *decoder) enter() os.Error {
+	r.depth++
+	if r.limits.MaxDepth > 0 && r.depth > r.limits.MaxDepth {
+		return ErrTooDeep
+	}
+	return nil
+}
+
+func (r *decoder) leave() {
+	r.depth--
+}
+
+// checkLength refuses a length before anything is allocated for it.
+func (r *decoder) checkLength(length int) os.Error {
+	if length < 0 {
+		return ErrNegativeLength
+	}
+	if r.limits.MaxArrayLen > 0 && length > r.limits.MaxArrayLen {
+		return ErrTooLong
+	}
+	return nil
+}
+
+// wrap adds a path component to an error coming up out of a compound
+// member or list item, making it a DecodeError at the current offset if it
+// isn't one already.
+func (r *decoder) wrap(err os.Error, component string) os.Error {
+	var decodeErr, isDecodeErr = err.(*DecodeError)
+	if !isDecodeErr {
+		decodeErr = &DecodeError{"", r.offset, err}
+	}
+
+	switch {
+	case component == "":
+	case decodeErr.Path == "":
+		decodeErr.Path = component
+	case decodeErr.Path[0] == '[':
+		decodeErr.Path = component + decodeErr.Path
+	default:
+		decodeErr.Path = component + "." + decodeErr.Path
+	}
+	return decodeErr
+}
+
+// fail makes err a DecodeError on its way out of the package. An empty
+// stream still gives a plain os.EOF.
+func (r *decoder) fail(err os.Error) os.Error {
+	if err == nil || err == os.EOF && r.offset == 0 {
+		return err
+	}
+	if _, isDecodeErr := err.(*DecodeError); isDecodeErr {
+		return err
+	}
+	return &DecodeError{"", r.offset, err}
+}
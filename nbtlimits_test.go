@@ -0,0 +1,152 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// decode reads data both as a tag tree and as a chunk, turning a panic into
+// an error so one bad input doesn't end the run.
+func decode(data []byte) (treeErr, chunkErr os.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			treeErr = os.NewError(fmt.Sprint("panic: ", r))
+			chunkErr = treeErr
+		}
+	}()
+	_, _, treeErr = Read(bytes.NewBuffer(data))
+	_, chunkErr = ReadChunk(bytes.NewBuffer(data), ChunkAll)
+	return
+}
+
+func isDecodeError(err os.Error) bool {
+	var _, is = err.(*DecodeError)
+	return is
+}
+
+// TestTruncated cuts each fixture short at every byte of its tag headers
+// and at intervals through its arrays.
+func TestTruncated(t *testing.T) {
+	for _, filename := range chunkFixtures {
+		var data, err = readFixture(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 1; n < len(data); n++ {
+			if n > 64 && n%97 != 0 {
+				continue
+			}
+			var treeErr, chunkErr = decode(data[:n])
+			if !isDecodeError(treeErr) || !isDecodeError(chunkErr) {
+				t.Errorf("%s cut to %d bytes: got %v and %v, want DecodeErrors", filename, n, treeErr, chunkErr)
+			}
+		}
+	}
+}
+
+// TestBitFlipped flips each bit of the tag headers and a spread of bits
+// through the arrays. Many flips still decode; any that don't must say so
+// with a DecodeError, not a panic.
+func TestBitFlipped(t *testing.T) {
+	for _, filename := range chunkFixtures {
+		var data, err = readFixture(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(data); i++ {
+			if i > 64 && i%89 != 0 {
+				continue
+			}
+			for bit := uint(0); bit < 8; bit++ {
+				var flipped = append([]byte(nil), data...)
+				flipped[i] ^= 1 << bit
+				var treeErr, chunkErr = decode(flipped)
+				if treeErr != nil && !isDecodeError(treeErr) || chunkErr != nil && !isDecodeError(chunkErr) {
+					t.Errorf("%s with bit %d of byte %d flipped: got %v and %v", filename, bit, i, treeErr, chunkErr)
+				}
+			}
+		}
+	}
+}
+
+// lengthPrefixes returns the offsets of the 32-bit length prefixes of the
+// arrays and lists in a Java NBT stream.
+func lengthPrefixes(data []byte) []int {
+	var offsets []int
+	var pos = 0
+
+	var readName = func() {
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos:]))
+	}
+	var payload func(typeId byte)
+	payload = func(typeId byte) {
+		switch typeId {
+		case tagInt8:
+			pos += 1
+		case tagInt16:
+			pos += 2
+		case tagInt32, tagFloat32:
+			pos += 4
+		case tagInt64, tagFloat64:
+			pos += 8
+		case tagString:
+			readName()
+		case tagByteArray, tagIntArray, tagLongArray:
+			offsets = append(offsets, pos)
+			var length = int(binary.BigEndian.Uint32(data[pos:]))
+			pos += 4 + length*payloadSizes[typeId]
+		case tagList:
+			var itemTypeId = data[pos]
+			offsets = append(offsets, pos+1)
+			var length = int(binary.BigEndian.Uint32(data[pos+1:]))
+			pos += 5
+			for i := 0; i < length; i++ {
+				payload(itemTypeId)
+			}
+		case tagStruct:
+			for {
+				var memberTypeId = data[pos]
+				pos++
+				if memberTypeId == tagStructEnd {
+					return
+				}
+				readName()
+				payload(memberTypeId)
+			}
+		}
+	}
+
+	var rootTypeId = data[pos]
+	pos++
+	readName()
+	payload(rootTypeId)
+	return offsets
+}
+
+// TestLengthPrefixes sets each array and list length in the fixtures to a
+// negative and then an over-long value, which must be refused up front.
+func TestLengthPrefixes(t *testing.T) {
+	for _, filename := range chunkFixtures {
+		var data, err = readFixture(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var offsets = lengthPrefixes(data)
+		if len(offsets) == 0 {
+			t.Fatalf("%s: no length prefixes found", filename)
+		}
+		for _, offset := range offsets {
+			for _, length := range []uint32{0xffffffff, 0x80000000, uint32(DefaultLimits.MaxArrayLen + 1), 0x7fffffff} {
+				var mutated = append([]byte(nil), data...)
+				binary.BigEndian.PutUint32(mutated[offset:], length)
+				var treeErr, chunkErr = decode(mutated)
+				if !isDecodeError(treeErr) || !isDecodeError(chunkErr) {
+					t.Errorf("%s with length %#x at byte %d: got %v and %v, want DecodeErrors", filename, length, offset, treeErr, chunkErr)
+				}
+			}
+		}
+	}
+}
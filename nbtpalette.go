@@ -0,0 +1,115 @@
+package nbt
+
+import (
+	"sort"
+	"strings"
+)
+
+// Chunks written before 20w17a (1.16) let a packed block state index span
+// two longs; later versions pad each long instead.
+const nonSpanningDataVersion = 2529
+
+// BlockState is one entry of a 1.13+ section palette, such as
+// minecraft:oak_stairs with facing=east.
+type BlockState struct {
+	Name       string
+	Properties map[string]string
+}
+
+var airBlockState = BlockState{"minecraft:air", nil}
+
+// String returns the state in the minecraft:name[key=value,...] form used by
+// commands, with the properties sorted by key.
+func (b *BlockState) String() string {
+	if len(b.Properties) == 0 {
+		return b.Name
+	}
+
+	var keys = make([]string, 0, len(b.Properties))
+	for key, _ := range b.Properties {
+		keys = append(keys, key)
+	}
+	sort.SortStrings(keys)
+
+	var pairs = make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + b.Properties[key]
+	}
+	return b.Name + "[" + strings.Join(pairs, ",") + "]"
+}
+
+// mergePalettedSection unpacks a section's block state indexes, remapping
+// them from the section's palette to the chunk wide one.
+func (chunk *Chunk) mergePalettedSection(s *section) {
+	var palette, blockStates = s.Palette, s.BlockStates
+	if s.States.Palette != nil {
+		palette, blockStates = s.States.Palette, s.States.Data
+	}
+
+	var indexes = make([]uint16, len(palette))
+	for i := range palette {
+		indexes[i] = chunk.paletteIndex(&palette[i])
+	}
+
+	var bits = 4
+	for 1<<uint(bits) < len(palette) {
+		bits++
+	}
+	var spanning = chunk.DataVersion < nonSpanningDataVersion
+
+	for i := 0; i < 16*16*16; i++ {
+		var (
+			x = i & 0xf
+			z = (i >> 4) & 0xf
+			y = int(s.Y)*16 - chunk.MinY + i>>8
+		)
+		var local = unpackIndex(blockStates, i, bits, spanning)
+		var block uint16
+		if local < len(indexes) {
+			block = indexes[local]
+		}
+		chunk.Blocks[y+(z*chunk.Height+(x*chunk.Height*16))] = block
+	}
+}
+
+func (chunk *Chunk) paletteIndex(state *BlockState) uint16 {
+	if chunk.Palette == nil {
+		chunk.Palette = []BlockState{airBlockState}
+		chunk.paletteIndexes = map[string]uint16{airBlockState.String(): 0}
+	}
+
+	var key = state.String()
+	var index, present = chunk.paletteIndexes[key]
+	if !present {
+		index = uint16(len(chunk.Palette))
+		chunk.Palette = append(chunk.Palette, *state)
+		chunk.paletteIndexes[key] = index
+	}
+	return index
+}
+
+// unpackIndex returns the i'th bits wide value packed into longs. A section
+// with a single entry palette may have no longs at all, meaning all zeros.
+func unpackIndex(longs []int64, i, bits int, spanning bool) int {
+	var mask = uint64(1)<<uint(bits) - 1
+
+	if spanning {
+		var bit = i * bits
+		var word, offset = bit / 64, uint(bit % 64)
+		if word >= len(longs) {
+			return 0
+		}
+		var value = uint64(longs[word]) >> offset
+		if offset+uint(bits) > 64 && word+1 < len(longs) {
+			value |= uint64(longs[word+1]) << (64 - offset)
+		}
+		return int(value & mask)
+	}
+
+	var perLong = 64 / bits
+	var word, offset = i / perLong, uint((i % perLong) * bits)
+	if word >= len(longs) {
+		return 0
+	}
+	return int((uint64(longs[word]) >> offset) & mask)
+}
@@ -0,0 +1,512 @@
+package nbt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// FormatOptions control WriteSNBT and WriteJSON. An empty Indent writes
+// everything on one line. Arrays and lists longer than a positive MaxItems
+// are cut short with a "... n more" marker, which makes the output
+// unparseable.
+type FormatOptions struct {
+	Indent   string
+	MaxItems int
+}
+
+// WriteSNBT writes tag in the stringified NBT form Minecraft commands use,
+// such as {Count:1b,id:"minecraft:stone"}.
+func WriteSNBT(writer io.Writer, tag Tag, options *FormatOptions) os.Error {
+	var f = &formatter{bufio.NewWriter(writer), options, false}
+	f.value(tag, 0)
+	return f.w.Flush()
+}
+
+// WriteJSON writes tag as JSON. Compounds become objects, and lists and
+// arrays become arrays, losing the NBT types.
+func WriteJSON(writer io.Writer, tag Tag, options *FormatOptions) os.Error {
+	var f = &formatter{bufio.NewWriter(writer), options, true}
+	f.value(tag, 0)
+	return f.w.Flush()
+}
+
+type formatter struct {
+	w       *bufio.Writer
+	options *FormatOptions
+	json    bool
+}
+
+func (f *formatter) newline(depth int) {
+	if f.options.Indent != "" {
+		f.w.WriteByte('\n')
+		for i := 0; i < depth; i++ {
+			f.w.WriteString(f.options.Indent)
+		}
+	}
+}
+
+func (f *formatter) value(tag Tag, depth int) {
+	switch t := tag.(type) {
+	case Byte:
+		f.number(strconv.Itoa(int(t)), "b")
+	case Short:
+		f.number(strconv.Itoa(int(t)), "s")
+	case Int:
+		f.number(strconv.Itoa(int(t)), "")
+	case Long:
+		f.number(strconv.Itoa64(int64(t)), "L")
+	case Float:
+		f.number(strconv.Ftoa32(float32(t), 'g', -1), "f")
+	case Double:
+		f.number(strconv.Ftoa64(float64(t), 'g', -1), "d")
+	case String:
+		f.w.WriteString(quote(string(t)))
+	case ByteArray:
+		f.array("B;", len(t), func(i int) { f.number(strconv.Itoa(int(int8(t[i]))), "b") })
+	case IntArray:
+		f.array("I;", len(t), func(i int) { f.number(strconv.Itoa(int(t[i])), "") })
+	case LongArray:
+		f.array("L;", len(t), func(i int) { f.number(strconv.Itoa64(t[i]), "L") })
+	case *List:
+		var nested = t.ElemType == TagCompound || t.ElemType == TagList
+		f.w.WriteByte('[')
+		for i, item := range t.Items {
+			if i != 0 {
+				f.w.WriteByte(',')
+			}
+			if f.truncated(i, len(t.Items)) {
+				break
+			}
+			if nested {
+				f.newline(depth + 1)
+			}
+			f.value(item, depth+1)
+		}
+		if nested && len(t.Items) != 0 {
+			f.newline(depth)
+		}
+		f.w.WriteByte(']')
+	case *Compound:
+		f.w.WriteByte('{')
+		for i, named := range t.Tags {
+			if i != 0 {
+				f.w.WriteByte(',')
+			}
+			f.newline(depth + 1)
+			if f.json || !isBareWord(named.Name) {
+				f.w.WriteString(quote(named.Name))
+			} else {
+				f.w.WriteString(named.Name)
+			}
+			f.w.WriteByte(':')
+			if f.options.Indent != "" {
+				f.w.WriteByte(' ')
+			}
+			f.value(named.Tag, depth+1)
+		}
+		if len(t.Tags) != 0 {
+			f.newline(depth)
+		}
+		f.w.WriteByte('}')
+	}
+}
+
+func (f *formatter) number(digits, suffix string) {
+	if f.json {
+		if digits == "NaN" || digits == "+Inf" || digits == "-Inf" {
+			f.w.WriteString(quote(digits))
+			return
+		}
+		f.w.WriteString(digits)
+		return
+	}
+	f.w.WriteString(digits)
+	f.w.WriteString(suffix)
+}
+
+func (f *formatter) array(prefix string, length int, item func(i int)) {
+	f.w.WriteByte('[')
+	if !f.json {
+		f.w.WriteString(prefix)
+	}
+	for i := 0; i < length; i++ {
+		if i != 0 {
+			f.w.WriteByte(',')
+		}
+		if f.truncated(i, length) {
+			break
+		}
+		item(i)
+	}
+	f.w.WriteByte(']')
+}
+
+// truncated writes the "... n more" marker in place of item i when
+// MaxItems cuts the array short there.
+func (f *formatter) truncated(i, length int) bool {
+	if f.options.MaxItems <= 0 || i < f.options.MaxItems {
+		return false
+	}
+	var marker = fmt.Sprintf("... %d more", length-i)
+	if f.json {
+		marker = quote(marker)
+	}
+	f.w.WriteString(marker)
+	return true
+}
+
+// quote writes a double quoted string that is valid both as SNBT and JSON.
+func quote(s string) string {
+	var b = make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c < 0x20:
+			b = append(b, fmt.Sprintf("\\u%04x", c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+func isBareWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isBareChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isBareChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '-' || c == '.' || c == '+'
+}
+
+// A SyntaxError describes where ParseSNBT gave up.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) String() string {
+	return fmt.Sprintf("snbt: %s at offset %d", e.Msg, e.Offset)
+}
+
+// ParseSNBT reads a tag written in stringified NBT, as produced by
+// WriteSNBT without MaxItems. Unsuffixed whole numbers are ints, other
+// unsuffixed numbers doubles, and true and false are bytes.
+func ParseSNBT(s string) (Tag, os.Error) {
+	var p = &snbtParser{s, 0}
+	var tag, err = p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, p.error("trailing data")
+	}
+	return tag, nil
+}
+
+type snbtParser struct {
+	s   string
+	pos int
+}
+
+func (p *snbtParser) error(msg string) os.Error {
+	return &SyntaxError{p.pos, msg}
+}
+
+func (p *snbtParser) skipSpace() {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// expect skips space and then c, reporting whether c was there.
+func (p *snbtParser) expect(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *snbtParser) value() (Tag, os.Error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, p.error("unexpected end")
+	}
+
+	switch p.s[p.pos] {
+	case '{':
+		return p.compound()
+	case '[':
+		if p.pos+2 < len(p.s) && p.s[p.pos+2] == ';' {
+			return p.array()
+		}
+		return p.list()
+	case '"', '\'':
+		var s, err = p.quoted()
+		return String(s), err
+	}
+
+	var word = p.word()
+	if word == "" {
+		return nil, p.error("unexpected " + strconv.Quote(p.s[p.pos:p.pos+1]))
+	}
+	return bareValue(word), nil
+}
+
+func (p *snbtParser) compound() (Tag, os.Error) {
+	p.pos++
+	var compound = new(Compound)
+	if p.expect('}') {
+		return compound, nil
+	}
+
+	for {
+		p.skipSpace()
+		var name string
+		if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+			var quoted, err = p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			name = quoted
+		} else {
+			name = p.word()
+			if name == "" {
+				return nil, p.error("expected a name")
+			}
+		}
+
+		if !p.expect(':') {
+			return nil, p.error("expected ':'")
+		}
+		var tag, err = p.value()
+		if err != nil {
+			return nil, err
+		}
+		compound.Tags = append(compound.Tags, NamedTag{name, tag})
+
+		if p.expect('}') {
+			return compound, nil
+		}
+		if !p.expect(',') {
+			return nil, p.error("expected ',' or '}'")
+		}
+	}
+	return compound, nil
+}
+
+func (p *snbtParser) list() (Tag, os.Error) {
+	p.pos++
+	var list = &List{TagEnd, nil}
+	if p.expect(']') {
+		return list, nil
+	}
+
+	for {
+		var tag, err = p.value()
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Items) == 0 {
+			list.ElemType = tag.Type()
+		} else if tag.Type() != list.ElemType {
+			return nil, p.error("list items must all have the same type")
+		}
+		list.Items = append(list.Items, tag)
+
+		if p.expect(']') {
+			return list, nil
+		}
+		if !p.expect(',') {
+			return nil, p.error("expected ',' or ']'")
+		}
+	}
+	return list, nil
+}
+
+// array reads [B;...], [I;...] and [L;...].
+func (p *snbtParser) array() (Tag, os.Error) {
+	var kind = p.s[p.pos+1]
+	p.pos += 3
+
+	var numbers []int64
+	for !p.expect(']') {
+		if len(numbers) != 0 && !p.expect(',') {
+			return nil, p.error("expected ',' or ']'")
+		}
+		p.skipSpace()
+		var n, isInt = Int64(bareValue(p.word()))
+		if !isInt {
+			return nil, p.error("expected an integer")
+		}
+		numbers = append(numbers, n)
+	}
+
+	switch kind {
+	case 'B':
+		var bytes = make(ByteArray, len(numbers))
+		for i, n := range numbers {
+			bytes[i] = byte(n)
+		}
+		return bytes, nil
+	case 'I':
+		var ints = make(IntArray, len(numbers))
+		for i, n := range numbers {
+			ints[i] = int32(n)
+		}
+		return ints, nil
+	case 'L':
+		return LongArray(numbers), nil
+	}
+	return nil, p.error("unknown array type " + string(kind))
+}
+
+func (p *snbtParser) quoted() (string, os.Error) {
+	var delimiter = p.s[p.pos]
+	p.pos++
+
+	var b []byte
+	for p.pos < len(p.s) {
+		var c = p.s[p.pos]
+		p.pos++
+		switch {
+		case c == delimiter:
+			return string(b), nil
+		case c == '\\' && p.pos < len(p.s):
+			c = p.s[p.pos]
+			p.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'u':
+				if p.pos+4 > len(p.s) {
+					return "", p.error("short \\u escape")
+				}
+				var code, err = strconv.Btoui64(p.s[p.pos:p.pos+4], 16)
+				if err != nil {
+					return "", p.error("bad \\u escape")
+				}
+				p.pos += 4
+				b = append(b, string(int(code))...)
+				continue
+			}
+			b = append(b, c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", p.error("unterminated string")
+}
+
+func (p *snbtParser) word() string {
+	var start = p.pos
+	for p.pos < len(p.s) && isBareChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// bareValue works out the type of an unquoted word from its suffix. As in
+// Minecraft's own parser, an integer too big for its type is a String.
+func bareValue(word string) Tag {
+	switch word {
+	case "":
+		return String(word)
+	case "true":
+		return Byte(1)
+	case "false":
+		return Byte(0)
+	}
+
+	var body, suffix = word[:len(word)-1], word[len(word)-1]
+	switch suffix {
+	case 'b', 'B':
+		if n, err := strconv.Atoi64(body); err == nil {
+			if !fitsBits(n, 8) {
+				return String(word)
+			}
+			return Byte(n)
+		}
+	case 's', 'S':
+		if n, err := strconv.Atoi64(body); err == nil {
+			if !fitsBits(n, 16) {
+				return String(word)
+			}
+			return Short(n)
+		}
+	case 'l', 'L':
+		if n, err := strconv.Atoi64(body); err == nil {
+			return Long(n)
+		} else if isInteger(body) {
+			return String(word)
+		}
+	case 'f', 'F':
+		if n, err := strconv.Atof32(body); err == nil {
+			return Float(n)
+		}
+	case 'd', 'D':
+		if n, err := strconv.Atof64(body); err == nil {
+			return Double(n)
+		}
+	}
+
+	if n, err := strconv.Atoi64(word); err == nil {
+		if !fitsBits(n, 32) {
+			return String(word)
+		}
+		return Int(n)
+	}
+	if isInteger(word) {
+		return String(word)
+	}
+	if n, err := strconv.Atof64(word); err == nil {
+		return Double(n)
+	}
+	return String(word)
+}
+
+// fitsBits reports whether n fits in a signed integer of the given size.
+func fitsBits(n int64, bits uint) bool {
+	var limit = int64(1) << (bits - 1)
+	return -limit <= n && n < limit
+}
+
+// isInteger reports whether s is an optionally signed run of digits, such
+// as one too big for strconv to read.
+func isInteger(s string) bool {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package nbt
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Action tells Walk what to do with the payload of the tag just visited.
+type Action int
+
+const (
+	Skip    Action = iota // Discard the payload without decoding it
+	Descend               // Visit the children of a compound or list one by one
+	Decode                // Decode the payload and pass it to Value
+)
+
+// A Visitor is shown each tag of a stream in turn. Paths are in the form
+// Lookup takes, such as "Level.Sections[2].Y".
+type Visitor interface {
+	Visit(path string, tagType TagType) Action
+	Value(path string, tag Tag)
+}
+
+// Walk streams an uncompressed NBT file past a Visitor. Payloads the visitor
+// skips are read past without being allocated.
+func Walk(reader io.Reader, v Visitor) os.Error {
+	return Java.Walk(reader, v)
+}
+
+// Walk is the package Walk for streams in format f.
+func (f *Format) Walk(reader io.Reader, v Visitor) os.Error {
+	var br = newDecoder(reader, f)
+
+	var typeId, name, err = readTag(br)
+	if err == nil {
+		err = walkPayload(br, typeId, name, v)
+	}
+	return br.fail(err)
+}
+
+// walkPayload visits the tag at path. Errors from its own reads are given
+// the path here; errors from its children already carry theirs.
+func walkPayload(br *decoder, typeId byte, path string, v Visitor) os.Error {
+	switch v.Visit(path, TagType(typeId)) {
+	case Decode:
+		var tag, err = readPayload(br, typeId)
+		if err != nil {
+			return br.wrap(err, path)
+		}
+		v.Value(path, tag)
+		return nil
+	case Descend:
+		switch typeId {
+		case tagStruct:
+			var err = br.enter()
+			if err != nil {
+				return br.wrap(err, path)
+			}
+			defer br.leave()
+
+			for {
+				var itemTypeId, name, err = readTag(br)
+				if err != nil {
+					return br.wrap(err, path)
+				}
+				if itemTypeId == tagStructEnd {
+					return nil
+				}
+
+				var itemPath = name
+				if path != "" {
+					itemPath = path + "." + name
+				}
+				err = walkPayload(br, itemTypeId, itemPath, v)
+				if err != nil {
+					return err
+				}
+			}
+		case tagList:
+			var itemTypeId, length, err = readListHeader(br)
+			if err == nil {
+				err = br.enter()
+			}
+			if err != nil {
+				return br.wrap(err, path)
+			}
+			defer br.leave()
+
+			for i := 0; i < length; i++ {
+				err = walkPayload(br, itemTypeId, path+"["+strconv.Itoa(i)+"]", v)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		var tag, err = readPayload(br, typeId)
+		if err != nil {
+			return br.wrap(err, path)
+		}
+		v.Value(path, tag)
+		return nil
+	}
+
+	var err = skipPayload(br, typeId)
+	if err != nil {
+		return br.wrap(err, path)
+	}
+	return nil
+}
+
+// A Selector picks which compound members UnmarshalSelected decodes. Each
+// key selects a member and the Selector under it picks from that member's
+// children; a nil Selector picks everything. List items are picked by the
+// list's Selector, so paths name compounds only.
+type Selector map[string]Selector
+
+// NewSelector builds a Selector from paths such as "Level.Sections.Blocks".
+func NewSelector(paths ...string) Selector {
+	var selector = make(Selector)
+	for _, path := range paths {
+		var node = selector
+		var names = strings.Split(path, ".", -1)
+		for i, name := range names {
+			var child, present = node[name]
+			if present && child == nil {
+				break
+			}
+			if i == len(names)-1 {
+				node[name] = nil
+				break
+			}
+			if !present {
+				child = make(Selector)
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return selector
+}
+
+// child returns the Selector for the named member, and whether it is
+// selected at all.
+func (s Selector) child(name string) (Selector, bool) {
+	if s == nil {
+		return nil, true
+	}
+	var child, present = s[name]
+	return child, present
+}
+
+// ChunkFields picks the parts of a chunk ReadChunk decodes. Positions and
+// heights are always read.
+type ChunkFields uint
+
+const (
+	ChunkBlocks ChunkFields = 1 << iota // Block ids or block states
+	ChunkData                           // Metadata nibbles of pre-1.13 chunks
+
+	ChunkAll = ChunkBlocks | ChunkData
+)
+
+var chunkSelectors = make(map[ChunkFields]Selector)
+
+func init() {
+	for fields := ChunkFields(0); fields <= ChunkAll; fields++ {
+		var paths = []string{"DataVersion", "xPos", "zPos", "Level.xPos", "Level.zPos", "sections.Y", "Level.Sections.Y"}
+		if fields&ChunkBlocks != 0 {
+			paths = append(paths, "Level.Blocks", "Level.Sections.Blocks", "Level.Sections.Add", "Level.Sections.Palette", "Level.Sections.BlockStates", "sections.block_states")
+		}
+		if fields&ChunkData != 0 {
+			paths = append(paths, "Level.Data", "Level.Sections.Data")
+		}
+		chunkSelectors[fields] = NewSelector(paths...)
+	}
+}
@@ -0,0 +1,340 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type TagType byte
+
+const (
+	TagEnd       TagType = tagStructEnd
+	TagByte      TagType = tagInt8
+	TagShort     TagType = tagInt16
+	TagInt       TagType = tagInt32
+	TagLong      TagType = tagInt64
+	TagFloat     TagType = tagFloat32
+	TagDouble    TagType = tagFloat64
+	TagByteArray TagType = tagByteArray
+	TagString    TagType = tagString
+	TagList      TagType = tagList
+	TagCompound  TagType = tagStruct
+	TagIntArray  TagType = tagIntArray
+	TagLongArray TagType = tagLongArray
+)
+
+var tagTypeNames = map[TagType]string{
+	TagEnd:       "TAG_End",
+	TagByte:      "TAG_Byte",
+	TagShort:     "TAG_Short",
+	TagInt:       "TAG_Int",
+	TagLong:      "TAG_Long",
+	TagFloat:     "TAG_Float",
+	TagDouble:    "TAG_Double",
+	TagByteArray: "TAG_Byte_Array",
+	TagString:    "TAG_String",
+	TagList:      "TAG_List",
+	TagCompound:  "TAG_Compound",
+	TagIntArray:  "TAG_Int_Array",
+	TagLongArray: "TAG_Long_Array",
+}
+
+func (t TagType) String() string {
+	var name, known = tagTypeNames[t]
+	if !known {
+		return fmt.Sprintf("TAG_Unknown(%d)", byte(t))
+	}
+	return name
+}
+
+// Tag is any value in an NBT tree. Compounds and lists are held as
+// *Compound and *List, everything else by value.
+type Tag interface {
+	Type() TagType
+}
+
+type Byte int8
+type Short int16
+type Int int32
+type Long int64
+type Float float32
+type Double float64
+type ByteArray []byte
+type String string
+type IntArray []int32
+type LongArray []int64
+
+// List holds tags of a single type. ElemType is kept for empty lists, whose
+// element type is still written out.
+type List struct {
+	ElemType TagType
+	Items    []Tag
+}
+
+// Compound keeps its tags in file order so that a tree can be written back
+// out unchanged.
+type Compound struct {
+	Tags []NamedTag
+}
+
+type NamedTag struct {
+	Name string
+	Tag  Tag
+}
+
+func (Byte) Type() TagType      { return TagByte }
+func (Short) Type() TagType     { return TagShort }
+func (Int) Type() TagType       { return TagInt }
+func (Long) Type() TagType      { return TagLong }
+func (Float) Type() TagType     { return TagFloat }
+func (Double) Type() TagType    { return TagDouble }
+func (ByteArray) Type() TagType { return TagByteArray }
+func (String) Type() TagType    { return TagString }
+func (*List) Type() TagType     { return TagList }
+func (*Compound) Type() TagType { return TagCompound }
+func (IntArray) Type() TagType  { return TagIntArray }
+func (LongArray) Type() TagType { return TagLongArray }
+
+// Get returns the named tag, or nil if the compound doesn't have one.
+func (c *Compound) Get(name string) Tag {
+	for _, named := range c.Tags {
+		if named.Name == name {
+			return named.Tag
+		}
+	}
+	return nil
+}
+
+// Set replaces the named tag, or appends it if the compound doesn't have one.
+func (c *Compound) Set(name string, tag Tag) {
+	for i, named := range c.Tags {
+		if named.Name == name {
+			c.Tags[i].Tag = tag
+			return
+		}
+	}
+	c.Tags = append(c.Tags, NamedTag{name, tag})
+}
+
+func (l *List) Len() int {
+	return len(l.Items)
+}
+
+// Read decodes one named tag, normally the root compound of a file, from an
+// uncompressed Java Edition NBT stream.
+func Read(reader io.Reader) (name string, tag Tag, err os.Error) {
+	return Java.Read(reader)
+}
+
+// Read decodes one named tag from an uncompressed stream in format f.
+func (f *Format) Read(reader io.Reader) (name string, tag Tag, err os.Error) {
+	var br = newDecoder(reader, f)
+
+	var typeId byte
+	typeId, name, err = readTag(br)
+	if err != nil {
+		return name, nil, br.fail(err)
+	}
+	if typeId == tagStructEnd {
+		return name, nil, os.NewError("nbt: root tag is TAG_End")
+	}
+
+	tag, err = readPayload(br, typeId)
+	return name, tag, br.fail(err)
+}
+
+func readPayload(br *decoder, typeId byte) (Tag, os.Error) {
+	switch typeId {
+	case tagInt8:
+		var n, err = readInt8(br)
+		return Byte(int8(n)), err
+	case tagInt16:
+		var n, err = readInt16(br)
+		return Short(int16(n)), err
+	case tagInt32:
+		var n, err = readInt32(br)
+		return Int(int32(n)), err
+	case tagInt64:
+		var n, err = readUint64(br)
+		return Long(int64(n)), err
+	case tagFloat32:
+		var n, err = readIntN(br, 4)
+		return Float(math.Float32frombits(uint32(n))), err
+	case tagFloat64:
+		var n, err = readFixed64(br)
+		return Double(math.Float64frombits(n)), err
+	case tagByteArray:
+		var bytes, err = readBytes(br)
+		return ByteArray(bytes), err
+	case tagString:
+		var s, err = readString(br)
+		return String(s), err
+	case tagList:
+		return readList(br)
+	case tagStruct:
+		return readCompound(br)
+	case tagIntArray:
+		var length, err = readLength(br)
+		if err != nil {
+			return nil, err
+		}
+		var ints = make(IntArray, length)
+		for i := range ints {
+			var n, err2 = readInt32(br)
+			if err2 != nil {
+				return ints, err2
+			}
+			ints[i] = int32(n)
+		}
+		return ints, nil
+	case tagLongArray:
+		var longs, err = readLongs(br)
+		var result = make(LongArray, len(longs))
+		for i, long := range longs {
+			result[i] = int64(long)
+		}
+		return result, err
+	}
+	return nil, ErrTagUnknown
+}
+
+func readList(br *decoder) (*List, os.Error) {
+	var itemTypeId, length, err = readListHeader(br)
+	if err == nil {
+		err = br.enter()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer br.leave()
+
+	var list = &List{TagType(itemTypeId), make([]Tag, 0, length)}
+	for i := 0; i < length; i++ {
+		var item, err2 = readPayload(br, itemTypeId)
+		if err2 != nil {
+			return list, br.wrap(err2, "["+strconv.Itoa(i)+"]")
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list, nil
+}
+
+func readCompound(br *decoder) (*Compound, os.Error) {
+	var compound = new(Compound)
+	var err = br.enter()
+	if err != nil {
+		return compound, err
+	}
+	defer br.leave()
+
+	for {
+		var typeId, name, err = readTag(br)
+		if err != nil {
+			return compound, err
+		}
+		if typeId == tagStructEnd {
+			return compound, nil
+		}
+
+		var tag, err2 = readPayload(br, typeId)
+		if err2 != nil {
+			return compound, br.wrap(err2, name)
+		}
+		compound.Tags = append(compound.Tags, NamedTag{name, tag})
+	}
+	return compound, nil
+}
+
+// Lookup follows a path such as "Level.Sections[2].Y" down from tag.
+// Names select from compounds and [n] indexes lists and arrays.
+func Lookup(tag Tag, path string) (Tag, os.Error) {
+	var rest = path
+	for rest != "" {
+		if rest[0] == '[' {
+			var end = strings.Index(rest, "]")
+			if end == -1 {
+				return nil, os.NewError("nbt: unterminated index in " + path)
+			}
+			var index, indexErr = strconv.Atoi(rest[1:end])
+			if indexErr != nil {
+				return nil, os.NewError("nbt: bad index in " + path)
+			}
+
+			tag = elementAt(tag, index)
+			rest = rest[end+1:]
+		} else {
+			var end = 0
+			for end < len(rest) && rest[end] != '.' && rest[end] != '[' {
+				end++
+			}
+
+			var compound, isCompound = tag.(*Compound)
+			if !isCompound {
+				return nil, os.NewError("nbt: no tag at " + path)
+			}
+			tag = compound.Get(rest[:end])
+			rest = rest[end:]
+		}
+
+		if tag == nil {
+			return nil, os.NewError("nbt: no tag at " + path)
+		}
+		if rest != "" && rest[0] == '.' {
+			rest = rest[1:]
+		}
+	}
+	return tag, nil
+}
+
+func elementAt(tag Tag, index int) Tag {
+	switch t := tag.(type) {
+	case *List:
+		if index >= 0 && index < len(t.Items) {
+			return t.Items[index]
+		}
+	case ByteArray:
+		if index >= 0 && index < len(t) {
+			return Byte(int8(t[index]))
+		}
+	case IntArray:
+		if index >= 0 && index < len(t) {
+			return Int(t[index])
+		}
+	case LongArray:
+		if index >= 0 && index < len(t) {
+			return Long(t[index])
+		}
+	}
+	return nil
+}
+
+// Int64 returns the value of any integer tag.
+func Int64(tag Tag) (int64, bool) {
+	switch t := tag.(type) {
+	case Byte:
+		return int64(t), true
+	case Short:
+		return int64(t), true
+	case Int:
+		return int64(t), true
+	case Long:
+		return int64(t), true
+	}
+	return 0, false
+}
+
+// Float64 returns the value of any numeric tag.
+func Float64(tag Tag) (float64, bool) {
+	switch t := tag.(type) {
+	case Float:
+		return float64(t), true
+	case Double:
+		return float64(t), true
+	}
+	var n, isInt = Int64(tag)
+	return float64(n), isInt
+}
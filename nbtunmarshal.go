@@ -0,0 +1,333 @@
+package nbt
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnmarshalTarget = os.NewError("Unmarshal needs a non-nil pointer")
+)
+
+// An UnmarshalTypeError is returned when a tag can't be stored in the Go
+// value its name maps to.
+type UnmarshalTypeError struct {
+	Name    string
+	TagType TagType
+	Type    reflect.Type
+}
+
+func (e *UnmarshalTypeError) String() string {
+	return "nbt: cannot unmarshal " + e.TagType.String() + " " + e.Name + " into Go value of type " + e.Type.String()
+}
+
+// Unmarshal decodes the root tag read from an uncompressed NBT stream into
+// the value v points to.
+//
+// Compounds decode into structs or map[string]T. A struct field receives the
+// tag named by its `nbt:"Name"` field tag, or by the field name when it has
+// none; a tag of "-" leaves the field alone. Lists decode into slices, byte,
+// int and long arrays into slices of any integer type, numbers into any
+// numeric type and strings into strings. An interface field receives the
+// tag as a Tag tree. Tags with no matching field are skipped without
+// allocating anything for them.
+func Unmarshal(reader io.Reader, v interface{}) os.Error {
+	return UnmarshalSelected(reader, v, nil)
+}
+
+// UnmarshalSelected is Unmarshal restricted to the members selector picks
+// out of the root compound; the rest are skipped as if v had no field for
+// them.
+func UnmarshalSelected(reader io.Reader, v interface{}, selector Selector) os.Error {
+	return Java.UnmarshalSelected(reader, v, selector)
+}
+
+// Unmarshal is the package Unmarshal for streams in format f.
+func (f *Format) Unmarshal(reader io.Reader, v interface{}) os.Error {
+	return f.UnmarshalSelected(reader, v, nil)
+}
+
+// UnmarshalSelected is the package UnmarshalSelected for streams in format f.
+func (f *Format) UnmarshalSelected(reader io.Reader, v interface{}, selector Selector) os.Error {
+	var br = newDecoder(reader, f)
+
+	var pv, isPtr = reflect.NewValue(v).(*reflect.PtrValue)
+	if !isPtr || pv.IsNil() {
+		return ErrUnmarshalTarget
+	}
+
+	var typeId, name, err = readTag(br)
+	if err == nil {
+		err = unmarshalPayload(br, typeId, name, pv.Elem(), selector)
+	}
+	return br.fail(err)
+}
+
+func unmarshalPayload(br *decoder, typeId byte, name string, v reflect.Value, selector Selector) os.Error {
+	switch dst := v.(type) {
+	case *reflect.PtrValue:
+		if dst.IsNil() {
+			dst.PointTo(reflect.MakeZero(dst.Type().(*reflect.PtrType).Elem()))
+		}
+		return unmarshalPayload(br, typeId, name, dst.Elem(), selector)
+	case *reflect.InterfaceValue:
+		var tag, err = readPayload(br, typeId)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.NewValue(tag))
+		return nil
+	}
+
+	switch typeId {
+	case tagInt8, tagInt16, tagInt32, tagInt64:
+		var n, err = readSigned(br, typeId)
+		if err != nil {
+			return err
+		}
+		if !setNumber(v, n, float64(n)) {
+			return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+		}
+		return nil
+	case tagFloat32, tagFloat64:
+		var tag, err = readPayload(br, typeId)
+		if err != nil {
+			return err
+		}
+		var f, _ = Float64(tag)
+		var fv, isFloat = v.(*reflect.FloatValue)
+		if !isFloat {
+			return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+		}
+		fv.Set(f)
+		return nil
+	case tagString:
+		var sv, isString = v.(*reflect.StringValue)
+		if !isString {
+			return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+		}
+		var s, err = readString(br)
+		if err != nil {
+			return err
+		}
+		sv.Set(s)
+		return nil
+	case tagByteArray, tagIntArray, tagLongArray:
+		var sv, isSlice = v.(*reflect.SliceValue)
+		if !isSlice {
+			return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+		}
+		return unmarshalArray(br, typeId, name, sv)
+	case tagList:
+		var sv, isSlice = v.(*reflect.SliceValue)
+		if !isSlice {
+			return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+		}
+		return unmarshalList(br, name, sv, selector)
+	case tagStruct:
+		switch dst := v.(type) {
+		case *reflect.StructValue:
+			return unmarshalStruct(br, dst, selector)
+		case *reflect.MapValue:
+			return unmarshalMap(br, name, dst, selector)
+		}
+		return &UnmarshalTypeError{name, TagType(typeId), v.Type()}
+	}
+	return ErrTagUnknown
+}
+
+func unmarshalStruct(br *decoder, sv *reflect.StructValue, selector Selector) os.Error {
+	var st = sv.Type().(*reflect.StructType)
+	var err = br.enter()
+	if err != nil {
+		return err
+	}
+	defer br.leave()
+
+	for {
+		var typeId, name, err = readTag(br)
+		if err != nil {
+			return err
+		}
+		if typeId == tagStructEnd {
+			return nil
+		}
+
+		var field, found = fieldForTag(st, name)
+		var child, selected = selector.child(name)
+		if found && selected {
+			err = unmarshalPayload(br, typeId, name, sv.Field(field), child)
+		} else {
+			err = skipPayload(br, typeId)
+		}
+		if err != nil {
+			return br.wrap(err, name)
+		}
+	}
+	return nil
+}
+
+func unmarshalMap(br *decoder, name string, mv *reflect.MapValue, selector Selector) os.Error {
+	var mt = mv.Type().(*reflect.MapType)
+	if mt.Key() != reflect.Typeof("") {
+		return &UnmarshalTypeError{name, TagCompound, mv.Type()}
+	}
+	if mv.IsNil() {
+		mv.SetValue(reflect.MakeMap(mt))
+	}
+
+	var err = br.enter()
+	if err != nil {
+		return err
+	}
+	defer br.leave()
+
+	for {
+		var typeId, key, err = readTag(br)
+		if err != nil {
+			return err
+		}
+		if typeId == tagStructEnd {
+			return nil
+		}
+
+		var child, selected = selector.child(key)
+		if !selected {
+			err = skipPayload(br, typeId)
+			if err != nil {
+				return br.wrap(err, key)
+			}
+			continue
+		}
+
+		var elem = reflect.MakeZero(mt.Elem())
+		err = unmarshalPayload(br, typeId, key, elem, child)
+		if err != nil {
+			return br.wrap(err, key)
+		}
+		mv.SetElem(reflect.NewValue(key), elem)
+	}
+	return nil
+}
+
+func unmarshalList(br *decoder, name string, sv *reflect.SliceValue, selector Selector) os.Error {
+	var itemTypeId, length, err = readListHeader(br)
+	if err == nil {
+		err = br.enter()
+	}
+	if err != nil {
+		return err
+	}
+	defer br.leave()
+
+	var slice = reflect.MakeSlice(sv.Type().(*reflect.SliceType), length, length)
+	for i := 0; i < length; i++ {
+		err = unmarshalPayload(br, itemTypeId, name, slice.Elem(i), selector)
+		if err != nil {
+			return br.wrap(err, "["+strconv.Itoa(i)+"]")
+		}
+	}
+	sv.Set(slice)
+	return nil
+}
+
+func unmarshalArray(br *decoder, typeId byte, name string, sv *reflect.SliceValue) os.Error {
+	if typeId == tagByteArray && sv.Type() == reflect.Typeof([]byte(nil)) {
+		var bytes, err = readBytes(br)
+		if err != nil {
+			return err
+		}
+		sv.Set(reflect.NewValue(bytes).(*reflect.SliceValue))
+		return nil
+	}
+
+	var length, err = readLength(br)
+	if err != nil {
+		return err
+	}
+
+	var itemTypeId byte = tagInt8
+	switch typeId {
+	case tagIntArray:
+		itemTypeId = tagInt32
+	case tagLongArray:
+		itemTypeId = tagInt64
+	}
+
+	var slice = reflect.MakeSlice(sv.Type().(*reflect.SliceType), length, length)
+	for i := 0; i < length; i++ {
+		var n, err2 = readSigned(br, itemTypeId)
+		if err2 != nil {
+			return err2
+		}
+		if !setNumber(slice.Elem(i), n, float64(n)) {
+			return &UnmarshalTypeError{name, TagType(typeId), sv.Type()}
+		}
+	}
+	sv.Set(slice)
+	return nil
+}
+
+func setNumber(v reflect.Value, n int64, f float64) bool {
+	switch dst := v.(type) {
+	case *reflect.IntValue:
+		dst.Set(n)
+	case *reflect.UintValue:
+		dst.Set(uint64(n))
+	case *reflect.FloatValue:
+		dst.Set(f)
+	case *reflect.BoolValue:
+		dst.Set(n != 0)
+	default:
+		return false
+	}
+	return true
+}
+
+// readSigned reads an integer tag payload, sign extending it.
+func readSigned(br *decoder, typeId byte) (int64, os.Error) {
+	switch typeId {
+	case tagInt8:
+		var n, err = readInt8(br)
+		return int64(int8(n)), err
+	case tagInt16:
+		var n, err = readInt16(br)
+		return int64(int16(n)), err
+	case tagInt32:
+		var n, err = readInt32(br)
+		return int64(int32(n)), err
+	}
+	var n, err = readUint64(br)
+	return int64(n), err
+}
+
+// fieldForTag finds the struct field a tag name maps to.
+func fieldForTag(st *reflect.StructType, name string) (int, bool) {
+	for i := 0; i < st.NumField(); i++ {
+		var field = st.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if fieldTagName(field) == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func fieldTagName(field reflect.StructField) string {
+	var tag = string(field.Tag)
+	var start = strings.Index(tag, `nbt:"`)
+	if start == -1 {
+		return field.Name
+	}
+	tag = tag[start+len(`nbt:"`):]
+	var end = strings.Index(tag, `"`)
+	if end == -1 {
+		return field.Name
+	}
+	return tag[:end]
+}
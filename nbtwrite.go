@@ -0,0 +1,231 @@
+package nbt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"math"
+	"os"
+)
+
+var (
+	ErrListMixed    = os.NewError("List items don't match the list's type")
+	ErrStringLength = os.NewError("String longer than 65535 bytes")
+)
+
+// Write encodes a named tag, normally the root compound, as uncompressed
+// Java Edition NBT. Reading a file with Read and writing it back with Write
+// gives the same bytes.
+func Write(writer io.Writer, name string, tag Tag) os.Error {
+	return Java.Write(writer, name, tag)
+}
+
+// Write encodes a named tag as uncompressed NBT in format f.
+func (f *Format) Write(writer io.Writer, name string, tag Tag) os.Error {
+	var bw = &encoder{bufio.NewWriter(writer), f}
+
+	var err = writeTag(bw, byte(tag.Type()), name)
+	if err == nil {
+		err = writePayload(bw, tag)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	return err
+}
+
+// WriteGzip writes the tag with the gzip framing used by level.dat, player
+// files and alpha chunks.
+func WriteGzip(writer io.Writer, name string, tag Tag) os.Error {
+	var gw, gzipErr = gzip.NewWriter(writer)
+	if gzipErr != nil {
+		return gzipErr
+	}
+
+	var err = Write(gw, name, tag)
+	var closeErr = gw.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// WriteZlib writes the tag with the zlib framing used for region file chunks.
+func WriteZlib(writer io.Writer, name string, tag Tag) os.Error {
+	var zw, zlibErr = zlib.NewWriter(writer)
+	if zlibErr != nil {
+		return zlibErr
+	}
+
+	var err = Write(zw, name, tag)
+	var closeErr = zw.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// ReadGzip is Read for gzip framed files.
+func ReadGzip(reader io.Reader) (string, Tag, os.Error) {
+	var r, rErr = gzip.NewReader(reader)
+	if rErr != nil {
+		return "", nil, rErr
+	}
+	defer r.Close()
+
+	return Read(r)
+}
+
+// ReadZlib is Read for zlib framed data.
+func ReadZlib(reader io.Reader) (string, Tag, os.Error) {
+	var r, rErr = zlib.NewReader(reader)
+	if rErr != nil {
+		return "", nil, rErr
+	}
+	defer r.Close()
+
+	return Read(r)
+}
+
+// ReadAny is Read for data that may be raw, gzip or zlib framed, telling
+// them apart by their first two bytes.
+func ReadAny(reader io.Reader) (string, Tag, os.Error) {
+	var header [2]byte
+	var n, err = io.ReadFull(reader, header[:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	var r = io.MultiReader(bytes.NewBuffer(header[:n]), reader)
+	switch {
+	case header[0] == 0x1f && header[1] == 0x8b:
+		return ReadGzip(r)
+	case header[0] == 0x78:
+		return ReadZlib(r)
+	}
+	return Read(r)
+}
+
+func writeTag(w *encoder, typeId byte, name string) os.Error {
+	var err = w.WriteByte(typeId)
+	if err != nil || typeId == tagStructEnd {
+		return err
+	}
+	return writeString(w, name)
+}
+
+func writePayload(w *encoder, tag Tag) os.Error {
+	switch t := tag.(type) {
+	case Byte:
+		return w.WriteByte(byte(t))
+	case Short:
+		return writeIntN(w, uint64(uint16(t)), 2)
+	case Int:
+		return writeInt32(w, int32(t))
+	case Long:
+		return writeInt64(w, int64(t))
+	case Float:
+		return writeIntN(w, uint64(math.Float32bits(float32(t))), 4)
+	case Double:
+		return writeIntN(w, math.Float64bits(float64(t)), 8)
+	case ByteArray:
+		var err = writeInt32(w, int32(len(t)))
+		if err == nil {
+			_, err = w.Write([]byte(t))
+		}
+		return err
+	case String:
+		return writeString(w, string(t))
+	case *List:
+		return writeList(w, t)
+	case *Compound:
+		for _, named := range t.Tags {
+			var err = writeTag(w, byte(named.Tag.Type()), named.Name)
+			if err == nil {
+				err = writePayload(w, named.Tag)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return w.WriteByte(tagStructEnd)
+	case IntArray:
+		var err = writeInt32(w, int32(len(t)))
+		for i := 0; err == nil && i < len(t); i++ {
+			err = writeInt32(w, t[i])
+		}
+		return err
+	case LongArray:
+		var err = writeInt32(w, int32(len(t)))
+		for i := 0; err == nil && i < len(t); i++ {
+			err = writeInt64(w, t[i])
+		}
+		return err
+	}
+	return ErrTagUnknown
+}
+
+func writeList(w *encoder, list *List) os.Error {
+	var err = w.WriteByte(byte(list.ElemType))
+	if err == nil {
+		err = writeInt32(w, int32(len(list.Items)))
+	}
+
+	for i := 0; err == nil && i < len(list.Items); i++ {
+		if list.Items[i].Type() != list.ElemType {
+			return ErrListMixed
+		}
+		err = writePayload(w, list.Items[i])
+	}
+	return err
+}
+
+func writeString(w *encoder, s string) os.Error {
+	if len(s) > 0xffff {
+		return ErrStringLength
+	}
+
+	var err os.Error
+	if w.format.VarInts {
+		err = writeVarint(w, uint64(len(s)))
+	} else {
+		err = writeIntN(w, uint64(len(s)), 2)
+	}
+	if err == nil {
+		_, err = w.WriteString(s)
+	}
+	return err
+}
+
+// writeInt32 writes an int, which is a varint in the network format.
+func writeInt32(w *encoder, n int32) os.Error {
+	if w.format.VarInts {
+		return writeZigzag(w, int64(n))
+	}
+	return writeIntN(w, uint64(uint32(n)), 4)
+}
+
+// writeInt64 writes a long, which is a varint in the network format.
+func writeInt64(w *encoder, n int64) os.Error {
+	if w.format.VarInts {
+		return writeZigzag(w, n)
+	}
+	return writeIntN(w, uint64(n), 8)
+}
+
+// writeIntN writes the low size bytes of n in the stream's byte order.
+func writeIntN(w *encoder, n uint64, size int) os.Error {
+	for i := 0; i < size; i++ {
+		var shift = uint(8 * (size - 1 - i))
+		if w.format.LittleEndian {
+			shift = uint(8 * i)
+		}
+		var err = w.WriteByte(byte(n >> shift))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"nbt"
 	"os"
 	"path"
 )
@@ -43,8 +44,13 @@ func (o *ObjGenerator) Start(outFilename string, total int, maxProcs int, bounda
 					b = &MemoryWriter{make([]byte, 0, 128*1024)}
 				}
 
-				var faceCount = faces.ProcessChunk(job.enclosed, b)
-				fmt.Fprintln(b)
+				var faceCount int
+				if meshCache == nil {
+					faceCount = faces.ProcessChunk(job.enclosed, b)
+					fmt.Fprintln(b)
+				} else {
+					faceCount = processCachedChunk(&faces, job.enclosed, b)
+				}
 
 				o.writeFacesChan <- &WriteFacesJob{job.enclosed.xPos, job.enclosed.zPos, faceCount, b, job.last}
 			}
@@ -114,11 +120,39 @@ func (o *ObjGenerator) GetCompleteChan() chan bool {
 	return o.completeChan
 }
 
+// ChunkFields leaves out block metadata when there are no materials for it
+// to pick between.
+func (o *ObjGenerator) ChunkFields() nbt.ChunkFields {
+	if noColor {
+		return nbt.ChunkBlocks
+	}
+	return nbt.ChunkAll
+}
+
 func (o *ObjGenerator) Close() {
 	o.out.Flush()
 	o.outFile.Close()
 }
 
+// processCachedChunk splices in the chunk's faces from the mesh cache, or
+// meshes the chunk and caches the faces if they aren't there.
+func processCachedChunk(faces *Faces, enclosed *EnclosedChunk, b *MemoryWriter) int {
+	var key = meshCache.Key(enclosed, "obj")
+	var cached, count, hit = meshCache.Get(enclosed.xPos, enclosed.zPos, "obj", key)
+	if hit {
+		// Meshing counts the faces towards -fk as it writes them.
+		b.Write(cached)
+		faceCount += count
+		return count
+	}
+
+	var start = len(b.buf)
+	count = faces.ProcessChunk(enclosed, b)
+	fmt.Fprintln(b)
+	meshCache.Put(enclosed.xPos, enclosed.zPos, "obj", key, b.buf[start:], count)
+	return count
+}
+
 type WriteFacesJob struct {
 	xPos, zPos, faceCount int
 	b                     *MemoryWriter
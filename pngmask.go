@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+)
+
+// ImageMask masks the blocks under transparent pixels of an image laid over
+// the world, and the chunks with no block under an opaque pixel. The image
+// is placed with its top left pixel over block x,z, north up, with each
+// pixel covering scale by scale blocks.
+type ImageMask struct {
+	img    image.Image
+	x, z   int
+	scale  int
+	chunks ListChunkMask
+	hash   string // Of the PNG file, so the mesh cache can tell images apart
+}
+
+// ReadImageMask reads the PNG filename as an ImageMask.
+func ReadImageMask(filename string, x, z, scale int) (*ImageMask, os.Error) {
+	if scale < 1 {
+		return nil, os.NewError("The image scale must be at least 1 block per pixel")
+	}
+
+	var data, readErr = ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var img, decodeErr = png.Decode(bytes.NewBuffer(data))
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	var h = sha1.New()
+	h.Write(data)
+	var m = &ImageMask{img, x, z, scale, make(ListChunkMask), hex.EncodeToString(h.Sum())}
+	var bounds = img.Bounds()
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			if !m.opaque(px, py) {
+				continue
+			}
+			var (
+				bx = x + (px-bounds.Min.X)*scale
+				bz = z + (py-bounds.Min.Y)*scale
+			)
+			for cx := floorDiv(bx, 16); cx <= floorDiv(bx+scale-1, 16); cx++ {
+				for cz := floorDiv(bz, 16); cz <= floorDiv(bz+scale-1, 16); cz++ {
+					m.chunks[betaChunkPoolKey(cx, cz)] = true
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *ImageMask) opaque(px, py int) bool {
+	var _, _, _, a = m.img.At(px, py).RGBA()
+	return a != 0
+}
+
+func (m *ImageMask) String() string {
+	return fmt.Sprintf("png %s at %d,%d scale %d", m.hash, m.x, m.z, m.scale)
+}
+
+func (m *ImageMask) IsMasked(x, z int) bool {
+	return m.chunks.IsMasked(x, z)
+}
+
+func (m *ImageMask) IsBlockMasked(x, y, z int) bool {
+	var bounds = m.img.Bounds()
+	var (
+		px = bounds.Min.X + floorDiv(x-m.x, m.scale)
+		py = bounds.Min.Y + floorDiv(z-m.z, m.scale)
+	)
+	if px < bounds.Min.X || px >= bounds.Max.X || py < bounds.Min.Y || py >= bounds.Max.Y {
+		return true
+	}
+	return !m.opaque(px, py)
+}
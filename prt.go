@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"nbt"
 	"os"
 )
 
@@ -54,45 +55,27 @@ func (o *PrtGenerator) Start(outFilename string, total int, maxProcs int, bounda
 
 func (o *PrtGenerator) chunkProcessor() {
 	var chunkCount = 0
+	var b = &MemoryWriter{make([]byte, 0, 128*1024)}
 	for {
 		var job = <-o.enclosedsChan
 
 		var e = job.enclosed
 
-		for i := 0; i < len(e.blocks); i += 128 {
-			var x, z = (i / 128) / 16, (i / 128) % 16
-
-			var column = BlockColumn(e.blocks[i : i+128])
-			for y, blockId := range column {
-				if y < yMin {
-					continue
-				}
-
-				switch {
-				case o.boundary.IsBoundary(blockId, e.Get(x, y-1, z)):
-					fallthrough
-				case o.boundary.IsBoundary(blockId, e.Get(x, y+1, z)):
-					fallthrough
-				case o.boundary.IsBoundary(blockId, e.Get(x-1, y, z)):
-					fallthrough
-				case o.boundary.IsBoundary(blockId, e.Get(x+1, y, z)):
-					fallthrough
-				case o.boundary.IsBoundary(blockId, e.Get(x, y, z-1)):
-					fallthrough
-				case o.boundary.IsBoundary(blockId, e.Get(x, y, z+1)):
-					o.particleCount++
-					var (
-						xa = -(x + e.xPos*16)
-						ya = y - 64
-						za = z + e.zPos*16
-					)
-					binary.Write(o.zw, binary.LittleEndian, float32(xa*2))
-					binary.Write(o.zw, binary.LittleEndian, float32(za*2))
-					binary.Write(o.zw, binary.LittleEndian, float32(ya*2))
-					binary.Write(o.zw, binary.LittleEndian, int32(blockId))
-				}
+		b.Clean()
+		if meshCache == nil {
+			o.particleCount += int64(o.processChunk(e, b))
+		} else {
+			var key = meshCache.Key(e, "prt")
+			var cached, count, hit = meshCache.Get(e.xPos, e.zPos, "prt", key)
+			if hit {
+				b.Write(cached)
+			} else {
+				count = o.processChunk(e, b)
+				meshCache.Put(e.xPos, e.zPos, "prt", key, b.buf, count)
 			}
+			o.particleCount += int64(count)
 		}
+		o.zw.Write(b.buf)
 
 		chunkCount++
 		fmt.Printf("%4v/%-4v (%3v,%3v) Particles: %d\n", chunkCount, o.total, job.enclosed.xPos, job.enclosed.zPos, o.particleCount)
@@ -104,6 +87,48 @@ func (o *PrtGenerator) chunkProcessor() {
 	}
 }
 
+// processChunk writes a particle for every block on a boundary, returning
+// the number written.
+func (o *PrtGenerator) processChunk(e *EnclosedChunk, w io.Writer) int {
+	var count = 0
+	var height = e.blocks.Height()
+	for i := 0; i < len(e.blocks); i += height {
+		var x, z = (i / height) / 16, (i / height) % 16
+
+		var column = BlockColumn(e.blocks[i : i+height])
+		for y, blockId := range column {
+			if e.IsClipped(x, y, z) {
+				continue
+			}
+
+			switch {
+			case o.boundary.IsBoundary(blockId, e.Get(x, y-1, z)):
+				fallthrough
+			case o.boundary.IsBoundary(blockId, e.Get(x, y+1, z)):
+				fallthrough
+			case o.boundary.IsBoundary(blockId, e.Get(x-1, y, z)):
+				fallthrough
+			case o.boundary.IsBoundary(blockId, e.Get(x+1, y, z)):
+				fallthrough
+			case o.boundary.IsBoundary(blockId, e.Get(x, y, z-1)):
+				fallthrough
+			case o.boundary.IsBoundary(blockId, e.Get(x, y, z+1)):
+				count++
+				var (
+					xa = -(x + e.xPos*16 - meshOriginX)
+					ya = y + e.minY - meshOriginY
+					za = z + e.zPos*16 - meshOriginZ
+				)
+				binary.Write(w, binary.LittleEndian, float32(xa*2))
+				binary.Write(w, binary.LittleEndian, float32(za*2))
+				binary.Write(w, binary.LittleEndian, float32(ya*2))
+				binary.Write(w, binary.LittleEndian, int32(blockId))
+			}
+		}
+	}
+	return count
+}
+
 func (o *PrtGenerator) Close() {
 	o.zw.Close()
 	o.w.Flush()
@@ -111,6 +136,10 @@ func (o *PrtGenerator) Close() {
 	o.outFile.Close()
 }
 
+func (o *PrtGenerator) ChunkFields() nbt.ChunkFields {
+	return nbt.ChunkAll
+}
+
 func (o *PrtGenerator) GetEnclosedJobsChan() chan *EnclosedChunkJob {
 	return o.enclosedsChan
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+)
+
+// regionHeaderSize is the 4KB table of chunk locations followed by the 4KB
+// table of chunk timestamps at the start of every region file.
+const regionHeaderSize = 8192
+
+// regionCacheSize bounds the number of region files held open at once. Each
+// covers 32x32 chunks, so this is plenty for walking outwards from a center.
+const regionCacheSize = 32
+
+var regionCache = NewRegionCache(regionCacheSize)
+
+var (
+	RegionHeaderError  = os.NewError("Region header truncated")
+	ChunkLocationError = os.NewError("Chunk location outside the region file")
+)
+
+// A RegionCache keeps the most recently used region files open with their
+// headers parsed, so that opening a chunk is a single read of its sectors.
+// Chunks are read with ReadAt and so can be opened from any number of
+// goroutines at once.
+//
+// Files are read rather than memory mapped, which keeps the cache working
+// on every platform mcobj builds for; a chunk is only a few sectors, so the
+// one read is no dearer than faulting the pages in.
+type RegionCache struct {
+	mutex    sync.Mutex
+	capacity int
+	regions  map[string]*regionFile
+	lru      *list.List
+}
+
+type regionFile struct {
+	file       *os.File
+	path       string
+	size       int64
+	locations  [1024]ChunkLocation
+	timestamps [1024]ChunkTimestamp
+	element    *list.Element
+	users      int  // Chunk reads in progress
+	evicted    bool // Dropped from the cache, to close once users is 0
+}
+
+func NewRegionCache(capacity int) *RegionCache {
+	return &RegionCache{capacity: capacity, regions: make(map[string]*regionFile), lru: list.New()}
+}
+
+// acquire returns the open region file for mcrPath, opening it and reading
+// its header if it isn't cached. Every acquire must be matched by a release.
+func (c *RegionCache) acquire(mcrPath string) (*regionFile, os.Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var region, cached = c.regions[mcrPath]
+	if cached {
+		c.lru.MoveToFront(region.element)
+		region.users++
+		return region, nil
+	}
+
+	var newRegion, openErr = openRegionFile(mcrPath)
+	if openErr != nil {
+		return nil, openErr
+	}
+	region = newRegion
+
+	for c.lru.Len() >= c.capacity {
+		var oldest = c.lru.Back().Value.(*regionFile)
+		c.lru.Remove(oldest.element)
+		c.regions[oldest.path] = nil, false
+		oldest.evicted = true
+		if oldest.users == 0 {
+			oldest.file.Close()
+		}
+	}
+
+	region.element = c.lru.PushFront(region)
+	c.regions[mcrPath] = region
+	region.users++
+	return region, nil
+}
+
+func (c *RegionCache) release(region *regionFile) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	region.users--
+	if region.evicted && region.users == 0 {
+		region.file.Close()
+	}
+}
+
+// Close closes every cached region file not in use.
+func (c *RegionCache) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for c.lru.Len() > 0 {
+		var region = c.lru.Remove(c.lru.Front()).(*regionFile)
+		c.regions[region.path] = nil, false
+		region.evicted = true
+		if region.users == 0 {
+			region.file.Close()
+		}
+	}
+}
+
+func openRegionFile(mcrPath string) (*regionFile, os.Error) {
+	var file, openErr = os.Open(mcrPath, os.O_RDONLY, 0666)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	var region = &regionFile{file: file, path: mcrPath}
+
+	// A region file Minecraft created but never wrote to is empty, which
+	// reads as a header with no chunks.
+	var header [regionHeaderSize]byte
+	var n, readErr = file.ReadAt(header[:], 0)
+	if readErr == os.EOF && n != 0 {
+		readErr = RegionHeaderError
+	}
+	if readErr != nil && readErr != os.EOF {
+		file.Close()
+		return nil, readErr
+	}
+
+	var fi, statErr = file.Stat()
+	if statErr != nil {
+		file.Close()
+		return nil, statErr
+	}
+	region.size = fi.Size
+
+	for i := range region.locations {
+		region.locations[i] = ChunkLocation(binary.BigEndian.Uint32(header[4*i:]))
+		region.timestamps[i] = ChunkTimestamp(binary.BigEndian.Uint32(header[4096+4*i:]))
+	}
+	return region, nil
+}
+
+// Locations returns the chunk location table of a region file, indexed by
+// (x&31)+(z&31)*32, and the size of the file.
+func (c *RegionCache) Locations(mcrPath string) ([1024]ChunkLocation, int64, os.Error) {
+	var region, err = c.acquire(mcrPath)
+	if err != nil {
+		return [1024]ChunkLocation{}, 0, err
+	}
+	defer c.release(region)
+
+	return region.locations, region.size, nil
+}
+
+// Timestamps returns the table of times the chunks of a region file were
+// last saved, indexed like Locations.
+func (c *RegionCache) Timestamps(mcrPath string) ([1024]ChunkTimestamp, os.Error) {
+	var region, err = c.acquire(mcrPath)
+	if err != nil {
+		return [1024]ChunkTimestamp{}, err
+	}
+	defer c.release(region)
+
+	return region.timestamps, nil
+}
+
+// OpenChunk opens chunk x,z of the region file at mcrPath, decompressing it
+// as its header says. Only the low five bits of x and z matter.
+func (c *RegionCache) OpenChunk(mcrPath string, x, z int) (io.ReadCloser, os.Error) {
+	var region, acquireErr = c.acquire(mcrPath)
+	if acquireErr != nil {
+		return nil, acquireErr
+	}
+	defer c.release(region)
+
+	var loc = region.locations[(x&31)+(z&31)*32]
+	if loc == 0 {
+		return nil, ChunkNotFoundError
+	}
+
+	// The last chunk in a file may not fill its final sector.
+	var sectors = make([]byte, loc.Sectors()*4096)
+	var n, readErr = region.file.ReadAt(sectors, int64(loc.Offset()))
+	if readErr != nil && !(readErr == os.EOF && n >= 5) {
+		return nil, readErr
+	}
+	sectors = sectors[:n]
+	if n < 5 {
+		return nil, ChunkLengthError
+	}
+
+	var (
+		length          = binary.BigEndian.Uint32(sectors[0:4])
+		compressionType = sectors[4]
+	)
+
+	if compressionType&externalChunkFlag != 0 {
+		var external, externalErr = openExternalChunk(mcrPath, x, z)
+		if externalErr != nil {
+			return nil, externalErr
+		}
+		var r, decompressErr = decompressChunk(external, compressionType&^externalChunkFlag)
+		if decompressErr != nil {
+			external.Close()
+			return nil, decompressErr
+		}
+		return &ReadCloserPair{r, external}, nil
+	}
+
+	// length counts the compression type byte.
+	if length == 0 || int64(length)+4 > int64(len(sectors)) {
+		return nil, ChunkLengthError
+	}
+	return decompressChunk(bytes.NewBuffer(sectors[5:4+length]), compressionType)
+}
@@ -1,15 +1,60 @@
 package main
 
 import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
 	"nbt"
+	"os"
 )
 
+// SideCache keeps the edges of chunks until the neighbours that need them
+// are enclosed. With a budget, the sides needed least recently are spilled
+// to a temporary file once the cache holds more than budget bytes of them,
+// and read back when they are needed.
 type SideCache struct {
-	chunks map[uint64]*ChunkSides
+	chunks  map[uint64]*list.Element // Sides in memory, as *cachedSides
+	lru     *list.List               // Front was needed most recently
+	spilled map[uint64]*spilledSides
+
+	budget   int64
+	size     int64 // Bytes of sides in memory
+	spill    *os.File
+	spillEnd int64
+
+	peakChunks, peakSpilled int
+	peakSize                int64
+}
+
+type cachedSides struct {
+	key   uint64
+	sides *ChunkSides
+	size  int64
 }
 
+// spilledSides locates a chunk's remaining sides in the spill file, stored
+// one after another as little-endian block ids.
+type spilledSides struct {
+	offset       int64
+	minY, height int
+	present      [4]bool
+}
+
+// NewSideCache makes a SideCache holding at most budget bytes of sides in
+// memory, or any amount if budget is 0.
+func NewSideCache(budget int64) *SideCache {
+	return &SideCache{budget: budget}
+}
+
+// Clear drops every side and removes the spill file.
 func (s *SideCache) Clear() {
-	s.chunks = nil
+	if s.spill != nil {
+		s.spill.Close()
+		os.Remove(s.spill.Name())
+	}
+	s.chunks, s.lru, s.spilled, s.spill = nil, nil, nil, nil
+	s.size, s.spillEnd = 0, 0
 }
 
 func (s *SideCache) AddChunk(chunk *nbt.Chunk) {
@@ -17,18 +62,18 @@ func (s *SideCache) AddChunk(chunk *nbt.Chunk) {
 		return
 	}
 
-	if s.chunks == nil {
-		s.chunks = make(map[uint64]*ChunkSides)
-	}
-
-	s.chunks[s.key(chunk.XPos, chunk.ZPos)] = calculateSides(chunk.Blocks)
+	s.store(s.key(chunk.XPos, chunk.ZPos), calculateSides(chunk.Blocks, chunk.MinY))
 }
 
 func (s *SideCache) HasSide(x, z int) bool {
 	if s.chunks == nil {
 		return false
 	}
-	var _, present = s.chunks[s.key(x, z)]
+	var key = s.key(x, z)
+	var _, present = s.chunks[key]
+	if !present {
+		_, present = s.spilled[key]
+	}
 	return present
 }
 
@@ -36,6 +81,7 @@ func (s *SideCache) EncloseChunk(chunk *nbt.Chunk) *EnclosedChunk {
 	return &EnclosedChunk{
 		chunk.XPos,
 		chunk.ZPos,
+		chunk.MinY,
 		chunk.Blocks,
 		EnclosingSides{
 			s.getSide(chunk.XPos-1, chunk.ZPos, 1),
@@ -46,8 +92,9 @@ func (s *SideCache) EncloseChunk(chunk *nbt.Chunk) *EnclosedChunk {
 	}
 }
 
-func calculateSides(blocks Blocks) *ChunkSides {
-	var sides = &ChunkSides{&ChunkSide{}, &ChunkSide{}, &ChunkSide{}, &ChunkSide{}}
+func calculateSides(blocks Blocks, minY int) *ChunkSides {
+	var height = blocks.Height()
+	var sides = &ChunkSides{NewChunkSide(minY, height), NewChunkSide(minY, height), NewChunkSide(minY, height), NewChunkSide(minY, height)}
 	for i := 0; i < 16; i++ {
 		copy(sides[0].Column(i), blocks.Column(0, i))
 		copy(sides[1].Column(i), blocks.Column(15, i))
@@ -62,22 +109,153 @@ func (s *SideCache) getSide(x, z int, side int) *ChunkSide {
 	if s.chunks == nil {
 		return defaultSide
 	}
-	var chunk, present = s.chunks[s.key(x, z)]
+
+	var key = s.key(x, z)
+	var _, spilled = s.spilled[key]
+	if spilled {
+		s.reload(key)
+	}
+
+	var element, present = s.chunks[key]
 	if !present {
 		return defaultSide
 	}
 
-	var chunkSide = chunk[side]
+	var cached = element.Value.(*cachedSides)
+	var chunkSide = cached.sides[side]
 
-	chunk[side] = nil
+	cached.sides[side] = nil
+	if chunkSide != nil {
+		cached.size -= chunkSide.size()
+		s.size -= chunkSide.size()
+	}
 
-	if chunk[0] == nil && chunk[1] == nil && chunk[2] == nil && chunk[3] == nil {
-		s.chunks[s.key(x, z)] = nil, false
+	if cached.sides[0] == nil && cached.sides[1] == nil && cached.sides[2] == nil && cached.sides[3] == nil {
+		s.lru.Remove(element)
+		s.chunks[key] = nil, false
+	} else {
+		s.lru.MoveToFront(element)
 	}
 
 	return chunkSide
 }
 
+// store puts sides in memory as the most recently needed, spilling others
+// if that takes the cache over budget.
+func (s *SideCache) store(key uint64, sides *ChunkSides) {
+	if s.chunks == nil {
+		s.chunks = make(map[uint64]*list.Element)
+		s.lru = list.New()
+		s.spilled = make(map[uint64]*spilledSides)
+	}
+
+	var cached = &cachedSides{key, sides, 0}
+	for _, side := range sides {
+		if side != nil {
+			cached.size += side.size()
+		}
+	}
+	s.chunks[key] = s.lru.PushFront(cached)
+	s.size += cached.size
+
+	for s.budget > 0 && s.size > s.budget && s.lru.Len() > 1 {
+		var spillErr = s.spillOldest()
+		if spillErr != nil {
+			fmt.Fprintln(os.Stderr, "Keeping all sides in memory:", spillErr)
+			s.budget = 0
+		}
+	}
+
+	if s.size > s.peakSize {
+		s.peakSize = s.size
+	}
+	if len(s.chunks)+len(s.spilled) > s.peakChunks {
+		s.peakChunks = len(s.chunks) + len(s.spilled)
+	}
+	if len(s.spilled) > s.peakSpilled {
+		s.peakSpilled = len(s.spilled)
+	}
+}
+
+// spillOldest writes the sides needed least recently to the spill file. The
+// file is only ever appended to; it is removed by Clear.
+func (s *SideCache) spillOldest() os.Error {
+	if s.spill == nil {
+		var file, tempErr = ioutil.TempFile("", "mcobj-sides")
+		if tempErr != nil {
+			return tempErr
+		}
+		s.spill = file
+	}
+
+	var element = s.lru.Back()
+	var cached = element.Value.(*cachedSides)
+
+	var record = &spilledSides{offset: s.spillEnd}
+	var buffer = make([]byte, 0, cached.size)
+	for i, side := range cached.sides {
+		if side == nil {
+			continue
+		}
+		record.present[i] = true
+		record.minY, record.height = side.minY, side.height
+		for _, blockId := range side.blocks {
+			buffer = append(buffer, byte(blockId), byte(blockId>>8))
+		}
+	}
+
+	var _, writeErr = s.spill.WriteAt(buffer, s.spillEnd)
+	if writeErr != nil {
+		return writeErr
+	}
+	s.spillEnd += int64(len(buffer))
+
+	s.lru.Remove(element)
+	s.chunks[cached.key] = nil, false
+	s.size -= cached.size
+	s.spilled[cached.key] = record
+	return nil
+}
+
+// reload reads spilled sides back into memory.
+func (s *SideCache) reload(key uint64) {
+	var record = s.spilled[key]
+	s.spilled[key] = nil, false
+
+	var sides ChunkSides
+	var count = 0
+	for i := range sides {
+		if record.present[i] {
+			sides[i] = NewChunkSide(record.minY, record.height)
+			count++
+		}
+	}
+
+	var buffer = make([]byte, count*record.height*16*2)
+	var _, readErr = s.spill.ReadAt(buffer, record.offset)
+	if readErr != nil {
+		fmt.Fprintln(os.Stderr, "Lost spilled sides:", readErr)
+		return
+	}
+
+	var offset = 0
+	for _, side := range sides {
+		if side == nil {
+			continue
+		}
+		for i := range side.blocks {
+			side.blocks[i] = binary.LittleEndian.Uint16(buffer[offset:])
+			offset += 2
+		}
+	}
+
+	s.store(key, &sides)
+}
+
+func (s *SideCache) String() string {
+	return fmt.Sprintf("Side cache: peak %d chunks, %d spilled to disk, %.1fMB in memory", s.peakChunks, s.peakSpilled, float64(s.peakSize)/1024/1024)
+}
+
 func (s *SideCache) key(x, z int) uint64 {
 	return (uint64(x) << 32) + uint64(z)
 }
@@ -7,33 +7,55 @@ var (
 )
 
 func init() {
-	for i, _ := range solidSide {
-		solidSide[i] = 1
-	}
+	solidSide.outside = 1
 
 	defaultSide = &solidSide
 }
 
-type ChunkSide [128 * 16]uint16
+// ChunkSide is one 16 column wide face of a chunk. Blocks outside the
+// side's minY..minY+height range read as outside, which lets emptySide and
+// solidSide stand in for a neighbour of any height.
+type ChunkSide struct {
+	minY    int
+	height  int
+	outside uint16
+	blocks  []uint16
+}
 type ChunkSides [4]*ChunkSide
 
+func NewChunkSide(minY, height int) *ChunkSide {
+	return &ChunkSide{minY, height, 0, make([]uint16, height*16)}
+}
+
 func (s *ChunkSides) Side(i int) *ChunkSide {
 	return (*s)[i]
 }
 
+// Index takes y relative to the bottom of the side.
 func (s *ChunkSide) Index(x, y int) int {
-	return y + (x * 128)
+	return y + (x * s.height)
 }
 
+// BlockId takes an absolute world y, as neighbouring chunks need not share
+// the same bottom.
 func (s *ChunkSide) BlockId(x, y int) uint16 {
-	return (*s)[s.Index(x, y)]
+	y -= s.minY
+	if y < 0 || y >= s.height {
+		return s.outside
+	}
+	return s.blocks[s.Index(x, y)]
 }
 
 func (s *ChunkSide) Column(x int) BlockColumn {
-	var i = 128 * x
-	return BlockColumn((*s)[i : i+128])
+	var i = s.height * x
+	return BlockColumn(s.blocks[i : i+s.height])
 }
 
 func (s *ChunkSide) SetBlockId(x, y int, blockId uint16) {
-	(*s)[s.Index(x, y)] = blockId
+	s.blocks[s.Index(x, y-s.minY)] = blockId
+}
+
+// size is the memory taken by the side's blocks.
+func (s *ChunkSide) size() int64 {
+	return int64(len(s.blocks)) * 2
 }
@@ -11,7 +11,7 @@ type ChunkOpener interface {
 }
 
 type ChunkPooler interface {
-	ChunkPool() (ChunkPool, os.Error)
+	ChunkPool(report *CorruptionReport) (ChunkPool, os.Error)
 }
 
 type World interface {
@@ -24,14 +24,65 @@ type ChunkPool interface {
 	Remaining() int
 }
 
-func OpenWorld(worldDir string, mask ChunkMask) World {
-	var _, err = os.Stat(path.Join(worldDir, "region"))
+// A ChunkTimestamper says when a chunk was last saved.
+type ChunkTimestamper interface {
+	ChunkTimestamp(x, z int) (ChunkTimestamp, os.Error)
+}
+
+// OpenChunkTimestamps returns the ChunkTimestamper for the world OpenWorld
+// would open.
+func OpenChunkTimestamps(worldDir string, dim *Dimension) ChunkTimestamper {
+	worldDir = dim.Path(worldDir)
+	var regionDirname = path.Join(worldDir, "region")
+	var _, err = os.Stat(regionDirname)
+	if err != nil {
+		return &alphaTimestamps{worldDir}
+	}
+	if hasRegionFiles(regionDirname, "mca") {
+		return &regionTimestamps{regionDirname, "mca"}
+	}
+	return &regionTimestamps{regionDirname, "mcr"}
+}
+
+// OpenWorld opens one dimension of the save at worldDir, in whichever of the
+// alpha, McRegion and Anvil layouts it is stored.
+func OpenWorld(worldDir string, dim *Dimension, mask ChunkMask) World {
+	worldDir = dim.Path(worldDir)
+	var regionDirname = path.Join(worldDir, "region")
+	var _, err = os.Stat(regionDirname)
 	if err != nil {
 		return &AlphaWorld{worldDir, mask}
 	}
+	if hasRegionFiles(regionDirname, "mca") {
+		return &AnvilWorld{worldDir, mask}
+	}
 	return &BetaWorld{worldDir, mask}
 }
 
+// hasRegionFiles reports whether dirname holds any r.X.Z.<ext> files. A
+// converted world keeps its old .mcr files next to the .mca ones.
+func hasRegionFiles(dirname, ext string) bool {
+	var dir, openErr = os.Open(dirname, os.O_RDONLY, 0666)
+	if openErr != nil {
+		return false
+	}
+	defer dir.Close()
+
+	for {
+		var filenames, readErr = dir.Readdirnames(64)
+		if readErr != nil || len(filenames) == 0 {
+			return false
+		}
+		for _, filename := range filenames {
+			var match, matchErr = path.Match("r.*.*."+ext, filename)
+			if match && matchErr == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type ReadCloserPair struct {
 	reader io.ReadCloser
 	closer io.Closer